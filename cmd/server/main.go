@@ -13,10 +13,14 @@ import (
 	"syscall"
 	"time"
 
+	"image-service/internal/allowlist"
 	"image-service/internal/cache"
 	"image-service/internal/handler"
 	"image-service/internal/middleware"
+	"image-service/internal/origin"
 	"image-service/internal/processor"
+	"image-service/internal/source"
+	"image-service/internal/ssrf"
 	"image-service/pkg/config"
 
 	"github.com/gorilla/mux"
@@ -26,46 +30,146 @@ import (
 func main() {
 	godotenv.Load()
 
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("❌ Failed to load config: %v", err)
+	}
 
 	log.Println("🚀 Starting Image Transformation Service...")
 	log.Printf("📝 Port: %s", cfg.Port)
 	log.Printf("📝 Allowed domains: %v", cfg.AllowedDomains)
-	log.Printf("📝 Redis: %s", cfg.RedisURL)
+	if len(cfg.DeniedDomains) > 0 {
+		log.Printf("📝 Denied domains: %v", cfg.DeniedDomains)
+	}
+	log.Printf("📝 Cache backend: %s", cfg.CacheBackend)
+	if cfg.CacheSoftTTL > 0 {
+		log.Printf("📝 Cache soft TTL: %ds, hard TTL: %ds", cfg.CacheSoftTTL, cfg.CacheTTL)
+	}
+	if len(cfg.Presets) > 0 {
+		log.Printf("📝 Presets: %d loaded (presets only: %t)", len(cfg.Presets), cfg.PresetsOnly)
+	}
+	if cfg.FallbackImage != "" || len(cfg.Fallbacks) > 0 {
+		log.Printf("📝 Fallback images: global %q, %d per preset", cfg.FallbackImage, len(cfg.Fallbacks))
+	}
+	if len(cfg.SigningKeys) > 0 {
+		log.Printf("📝 URL signing enabled (%d active keys)", len(cfg.SigningKeys))
+	}
 
-	cacheClient, err := cache.NewCache(cfg.RedisURL, cfg.RedisPassword, cfg.CacheTTL)
+	cacheClient, err := newCache(cfg)
 	if err != nil {
-		log.Fatalf("❌ Failed to connect to Redis: %v", err)
+		log.Fatalf("❌ Failed to initialize cache: %v", err)
 	}
 	defer cacheClient.Close()
-	log.Println("✅ Redis connected")
 
-	proc := processor.NewProcessor()
+	originals, err := newOriginals(cfg)
+	if err != nil {
+		log.Fatalf("❌ Failed to initialize originals cache: %v", err)
+	}
+	if originals != nil {
+		defer originals.Close()
+	}
+
+	proc := processor.NewProcessor(processor.Limits{
+		MaxConcurrent:      cfg.MaxTransforms,
+		QueueSize:          cfg.QueueSize,
+		QueueTimeout:       time.Duration(cfg.QueueTimeout) * time.Second,
+		MaxInputPixels:     cfg.MaxInputPixels,
+		MaxOutputDimension: cfg.MaxOutputDim,
+	})
 	defer proc.Shutdown()
-	log.Println("✅ Image processor initialized")
+	log.Printf("✅ Image processor initialized (%d concurrent, queue %d)", proc.Stats().MaxConcurrent, cfg.QueueSize)
+
+	origins, err := origin.NewRegistry(cfg.Origins)
+	if err != nil {
+		log.Fatalf("❌ Invalid origin config: %v", err)
+	}
+	if len(cfg.Origins) > 0 {
+		log.Printf("📝 Origins: %d configured", len(cfg.Origins))
+	}
+
+	// Configured origins are trusted without also listing them in ALLOWED_DOMAINS
+	allowed, err := allowlist.New(append(cfg.AllowedDomains, origins.BaseURLs()...), cfg.DeniedDomains)
+	if err != nil {
+		log.Fatalf("❌ Invalid domain rules: %v", err)
+	}
+
+	sources, err := newSources(cfg, origins, allowed)
+	if err != nil {
+		log.Fatalf("❌ Failed to initialize image sources: %v", err)
+	}
 
-	h := handler.NewHandler(cacheClient, proc, cfg.MaxImageSize)
+	h, err := handler.NewHandler(cacheClient, originals, proc, origins, sources, cfg)
+	if err != nil {
+		log.Fatalf("❌ Failed to initialize handler: %v", err)
+	}
 
 	r := mux.NewRouter()
 
 	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit)
 
+	// Sources in the path API are URLs themselves, don't let mux collapse
+	// their slashes. Every other route keeps its paths cleaned.
+	raw := mux.NewRouter().SkipClean(true)
+
+	transform := middleware.Signature(cfg.SigningKeys)(
+		middleware.Origins(origins)(
+			middleware.Auth(allowed)(
+				http.HandlerFunc(h.Transform),
+			),
+		),
+	)
+
 	r.HandleFunc("/health", h.Health).Methods("GET")
+	r.HandleFunc("/metrics", h.Metrics).Methods("GET")
+	r.Handle("/info",
+		rateLimiter.Limit(
+			middleware.Signature(cfg.SigningKeys)(
+				middleware.Origins(origins)(
+					middleware.Auth(allowed)(
+						http.HandlerFunc(h.Info),
+					),
+				),
+			),
+		),
+	).Methods("GET")
+	r.Handle("/transform", rateLimiter.Limit(transform)).Methods("GET")
 	r.Handle("/transform",
 		rateLimiter.Limit(
-			middleware.Auth(cfg.AllowedDomains)(
-				http.HandlerFunc(h.Transform),
+			middleware.Signature(cfg.SigningKeys)(
+				http.HandlerFunc(h.Upload),
 			),
 		),
+	).Methods("POST")
+	raw.PathPrefix("/t/").Handler(
+		rateLimiter.Limit(
+			middleware.PathParams("/t/")(transform),
+		),
+	).Methods("GET")
+	raw.PathPrefix("/p/").Handler(
+		rateLimiter.Limit(
+			middleware.PresetPathParams("/p/")(transform),
+		),
 	).Methods("GET")
 
-	r.Use(corsMiddleware)
-	r.Use(compressionMiddleware)
+	if cfg.AdminToken != "" {
+		r.Handle("/admin/cache",
+			middleware.AdminAuth(cfg.AdminToken)(
+				http.HandlerFunc(h.PurgeCache),
+			),
+		).Methods("DELETE")
+	} else {
+		log.Println("📝 Admin API disabled (ADMIN_TOKEN not set)")
+	}
+
+	for _, router := range []*mux.Router{r, raw} {
+		router.Use(corsMiddleware)
+		router.Use(compressionMiddleware)
+	}
 
 	addr := fmt.Sprintf(":%s", cfg.Port)
 	server := &http.Server{
 		Addr:         addr,
-		Handler:      r,
+		Handler:      pathAPI(r, raw),
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -96,10 +200,139 @@ func main() {
 	}
 }
 
+// pathAPI sends /t/ and /p/ requests to raw, everything else to clean.
+func pathAPI(clean, raw http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/t/") || strings.HasPrefix(r.URL.Path, "/p/") {
+			raw.ServeHTTP(w, r)
+			return
+		}
+		clean.ServeHTTP(w, r)
+	})
+}
+
+func newCache(cfg *config.Config) (cache.Cache, error) {
+	switch cfg.CacheBackend {
+	case "memory":
+		log.Printf("✅ In-memory cache ready (%d MB)", cfg.CacheMemSize/1024/1024)
+		return cache.NewMemoryCache(cfg.CacheMemSize, cfg.CacheTTL), nil
+
+	case "disk":
+		disk, err := cache.NewDiskCache(cfg.CacheDir, cfg.CacheDiskSize, cfg.CacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open disk cache: %w", err)
+		}
+		log.Printf("✅ Disk cache ready (%s, %d MB)", cfg.CacheDir, cfg.CacheDiskSize/1024/1024)
+		return disk, nil
+
+	case "tiered":
+		memory := cache.NewMemoryCache(cfg.CacheMemSize, cfg.CacheTTL)
+		redisCache, err := cache.NewRedisCache(cfg.RedisURL, cfg.RedisPassword, cfg.CacheTTL)
+		if err != nil {
+			// Keep serving from memory alone rather than refusing to start
+			log.Printf("⚠️  Redis unavailable, using in-memory cache only: %v", err)
+			return memory, nil
+		}
+		log.Printf("✅ Tiered cache ready (%d MB memory in front of Redis %s)", cfg.CacheMemSize/1024/1024, cfg.RedisURL)
+		return cache.NewTieredCache(memory, redisCache), nil
+
+	case "redis":
+		redisCache, err := cache.NewRedisCache(cfg.RedisURL, cfg.RedisPassword, cfg.CacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		log.Printf("✅ Redis connected (%s)", cfg.RedisURL)
+		return redisCache, nil
+
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.CacheBackend)
+	}
+}
+
+// newOriginals opens the cache for downloaded source images, nil when
+// ORIGINALS_CACHE isn't set.
+func newOriginals(cfg *config.Config) (*cache.Originals, error) {
+	maxAge := time.Duration(cfg.OriginalsAge) * time.Second
+
+	switch cfg.OriginalsCache {
+	case "":
+		return nil, nil
+
+	case "memory":
+		log.Printf("✅ Originals cache ready (%d MB in memory)", cfg.OriginalsSize/1024/1024)
+		return cache.NewOriginals(cache.NewMemoryCache(cfg.OriginalsSize, cfg.OriginalsTTL), maxAge), nil
+
+	case "disk":
+		disk, err := cache.NewDiskCache(cfg.OriginalsDir, cfg.OriginalsSize, cfg.OriginalsTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open disk cache: %w", err)
+		}
+		log.Printf("✅ Originals cache ready (%s, %d MB)", cfg.OriginalsDir, cfg.OriginalsSize/1024/1024)
+		return cache.NewOriginals(disk, maxAge), nil
+
+	case "redis":
+		redisCache, err := cache.NewRedisCache(cfg.RedisURL, cfg.RedisPassword, cfg.OriginalsTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		// Redis evicts by its own maxmemory policy, there is no size to pass
+		log.Printf("✅ Originals cache ready (Redis %s, ORIGINALS_CACHE_SIZE doesn't apply)", cfg.RedisURL)
+		return cache.NewOriginals(redisCache, maxAge), nil
+
+	default:
+		return nil, fmt.Errorf("unknown originals cache backend %q", cfg.OriginalsCache)
+	}
+}
+
+// newSources sets up fetching for every source URL scheme that is configured.
+// HTTP is always available, files and S3 only when set up.
+func newSources(cfg *config.Config, origins *origin.Registry, allowed *allowlist.List) (source.Sources, error) {
+	httpSource := source.NewHTTP(source.HTTPConfig{
+		MaxIdleConns:        cfg.OriginIdle,
+		MaxIdleConnsPerHost: cfg.OriginIdleHost,
+		MaxConnsPerHost:     cfg.OriginMaxConns,
+		IdleConnTimeout:     time.Duration(cfg.OriginIdleTime) * time.Second,
+		HTTP2:               cfg.OriginHTTP2,
+		Retries:             cfg.OriginRetries,
+		RetryBackoff:        time.Duration(cfg.OriginBackoff) * time.Millisecond,
+		MaxRedirects:        cfg.MaxRedirects,
+	}, origins, allowed, ssrf.NewGuard(cfg.AllowedCIDRs))
+	sources := source.Sources{
+		"http":  httpSource,
+		"https": httpSource,
+	}
+
+	if cfg.FileRoot != "" {
+		files, err := source.NewFile(cfg.FileRoot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file source root: %w", err)
+		}
+		sources["file"] = files
+		log.Printf("✅ File source ready (%s)", cfg.FileRoot)
+	}
+
+	if cfg.S3Endpoint != "" || cfg.S3AccessKey != "" {
+		s3, err := source.NewS3(source.S3Config{
+			Endpoint:     cfg.S3Endpoint,
+			Region:       cfg.S3Region,
+			AccessKey:    cfg.S3AccessKey,
+			SecretKey:    cfg.S3SecretKey,
+			SessionToken: cfg.S3SessionToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sources["s3"] = s3
+		log.Printf("✅ S3 source ready (region %s)", cfg.S3Region)
+	}
+
+	return sources, nil
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 		if r.Method == "OPTIONS" {
@@ -113,7 +346,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 
 func compressionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasPrefix(r.URL.Path, "/transform") {
+		if strings.HasPrefix(r.URL.Path, "/transform") || strings.HasPrefix(r.URL.Path, "/t/") || strings.HasPrefix(r.URL.Path, "/p/") {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -0,0 +1,173 @@
+// Package allowlist decides which origins the service may fetch images from.
+//
+// Rules look like:
+//
+//	example.com                      exactly this host, http(s) on any port
+//	*.example.com                    any subdomain of example.com, not the apex
+//	https://example.com              https only
+//	example.com:8443                 this port only
+//	https://cdn.example.com/images/  only paths under /images/
+//	s3://media/products/             keys under products/ in the media bucket
+//	file:///                         the whole local source directory
+//	*                                any http(s) URL
+//
+// Deny rules use the same syntax and are evaluated first. Bucket and file
+// sources are never matched by "*" or a rule without a scheme, they have to
+// be listed explicitly.
+//
+// Hosts used to be matched by suffix, so "example.com" covered its
+// subdomains. They now need their own "*.example.com" rule, see UPGRADING.md.
+package allowlist
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type rule struct {
+	any      bool // "*", http(s) only
+	scheme   string
+	host     string
+	wildcard bool // host is a "*." suffix
+	port     string
+	path     string
+}
+
+type List struct {
+	allow []rule
+	deny  []rule
+}
+
+func New(allowed, denied []string) (*List, error) {
+	l := &List{}
+	var err error
+	if l.allow, err = parseRules(allowed); err != nil {
+		return nil, err
+	}
+	if l.deny, err = parseRules(denied); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func parseRules(entries []string) ([]rule, error) {
+	var rules []rule
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		r, err := parseRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid domain rule %q: %w", entry, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func parseRule(entry string) (rule, error) {
+	if entry == "*" {
+		return rule{any: true}, nil
+	}
+
+	if !strings.Contains(entry, "://") {
+		// Let url.Parse split host, port and path
+		entry = "//" + entry
+	}
+	u, err := url.Parse(entry)
+	if err != nil {
+		return rule{}, err
+	}
+	if u.Host == "" && u.Scheme != "file" {
+		return rule{}, fmt.Errorf("missing host")
+	}
+
+	r := rule{
+		scheme: strings.ToLower(u.Scheme),
+		host:   normalizeHost(u.Hostname()),
+		port:   u.Port(),
+		path:   u.Path,
+	}
+	if suffix, found := strings.CutPrefix(r.host, "*."); found {
+		r.host = suffix
+		r.wildcard = true
+	}
+	if strings.Contains(r.host, "*") {
+		return rule{}, fmt.Errorf("wildcards are only supported as a leading \"*.\"")
+	}
+
+	return r, nil
+}
+
+// Allowed reports whether u matches an allow rule and no deny rule.
+func (l *List) Allowed(u *url.URL) bool {
+	for _, r := range l.deny {
+		if r.matches(u) {
+			return false
+		}
+	}
+	for _, r := range l.allow {
+		if r.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) matches(u *url.URL) bool {
+	scheme := strings.ToLower(u.Scheme)
+	web := scheme == "http" || scheme == "https"
+	switch {
+	case r.any:
+		return web
+	case r.scheme == "" && !web:
+		// Like "*", a rule without a scheme doesn't reach buckets or files
+		return false
+	case r.scheme != "" && r.scheme != scheme:
+		return false
+	}
+
+	host := normalizeHost(u.Hostname())
+	if r.wildcard {
+		if !strings.HasSuffix(host, "."+r.host) {
+			return false
+		}
+	} else if host != r.host {
+		return false
+	}
+
+	if r.port != "" && r.port != effectivePort(u) {
+		return false
+	}
+
+	if r.path != "" && r.path != "/" {
+		// Clean first so /images/../private doesn't slip through
+		p := path.Clean("/" + u.Path)
+		prefix := strings.TrimSuffix(r.path, "/")
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
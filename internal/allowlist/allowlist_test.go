@@ -0,0 +1,76 @@
+package allowlist
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		url     string
+		want    bool
+	}{
+		{"exact host", []string{"example.com"}, nil, "https://example.com/a.jpg", true},
+		{"exact host, http or https", []string{"example.com"}, nil, "http://example.com/a.jpg", true},
+		{"exact host, case and trailing dot", []string{"Example.COM"}, nil, "https://EXAMPLE.com./a.jpg", true},
+		{"exact host skips subdomains", []string{"example.com"}, nil, "https://cdn.example.com/a.jpg", false},
+		{"no suffix match", []string{"example.com"}, nil, "https://evilexample.com/a.jpg", false},
+		{"no suffix match on port", []string{"example.com"}, nil, "https://example.com.evil.net/a.jpg", false},
+		{"wildcard subdomain", []string{"*.example.com"}, nil, "https://cdn.example.com/a.jpg", true},
+		{"wildcard nested subdomain", []string{"*.example.com"}, nil, "https://a.b.example.com/a.jpg", true},
+		{"wildcard skips apex", []string{"*.example.com"}, nil, "https://example.com/a.jpg", false},
+		{"wildcard no suffix match", []string{"*.example.com"}, nil, "https://evilexample.com/a.jpg", false},
+		{"scheme", []string{"https://example.com"}, nil, "https://example.com/a.jpg", true},
+		{"wrong scheme", []string{"https://example.com"}, nil, "http://example.com/a.jpg", false},
+		{"port", []string{"example.com:8443"}, nil, "https://example.com:8443/a.jpg", true},
+		{"wrong port", []string{"example.com:8443"}, nil, "https://example.com/a.jpg", false},
+		{"default port", []string{"https://example.com:443"}, nil, "https://example.com/a.jpg", true},
+		{"path prefix", []string{"https://example.com/images/"}, nil, "https://example.com/images/a.jpg", true},
+		{"outside path prefix", []string{"https://example.com/images/"}, nil, "https://example.com/private/a.jpg", false},
+		{"path prefix is a directory", []string{"https://example.com/images"}, nil, "https://example.com/images-private/a.jpg", false},
+		{"path traversal", []string{"https://example.com/images/"}, nil, "https://example.com/images/../private/a.jpg", false},
+		{"encoded path traversal", []string{"https://example.com/images/"}, nil, "https://example.com/images/%2e%2e/private/a.jpg", false},
+		{"star is http(s)", []string{"*"}, nil, "https://anything.net/a.jpg", true},
+		{"star skips files", []string{"*"}, nil, "file:///etc/passwd", false},
+		{"star skips buckets", []string{"*"}, nil, "s3://media/a.jpg", false},
+		{"file rule", []string{"file:///"}, nil, "file:///photos/a.jpg", true},
+		{"bucket prefix", []string{"s3://media/products/"}, nil, "s3://media/products/a.jpg", true},
+		{"other bucket", []string{"s3://media/products/"}, nil, "s3://other/products/a.jpg", false},
+		{"schemeless skips buckets", []string{"cdn.example.com"}, nil, "s3://cdn.example.com/x", false},
+		{"schemeless skips files", []string{"cdn.example.com"}, nil, "file://cdn.example.com/x", false},
+		{"deny wins", []string{"*"}, []string{"internal.example.com"}, "https://internal.example.com/a.jpg", false},
+		{"deny wildcard", []string{"*.example.com"}, []string{"*.private.example.com"}, "https://x.private.example.com/a.jpg", false},
+		{"deny leaves others", []string{"*"}, []string{"internal.example.com"}, "https://example.com/a.jpg", true},
+		{"userinfo isn't the host", []string{"allowed.com"}, nil, "http://allowed.com%2F@evil-host/", false},
+		{"userinfo with allowed host", []string{"allowed.com"}, nil, "http://user@allowed.com/a.jpg", true},
+		{"nothing allowed", nil, nil, "https://example.com/a.jpg", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := New(tt.allowed, tt.denied)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := l.Allowed(u); got != tt.want {
+				t.Errorf("Allowed(%s) = %t, want %t", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	for _, rule := range []string{"https://", "ex*ample.com", "cdn.*.example.com", "http://[::1"} {
+		if _, err := New([]string{rule}, nil); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", rule)
+		}
+	}
+}
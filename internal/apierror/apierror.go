@@ -0,0 +1,94 @@
+// Package apierror is the error model shared by handlers and middleware.
+// Every failure carries an HTTP status and a stable, machine-readable code,
+// and is written as JSON to clients that accept it, plain text otherwise.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type Code string
+
+const (
+	InvalidParam      Code = "INVALID_PARAM"
+	InvalidSignature  Code = "INVALID_SIGNATURE"
+	DomainNotAllowed  Code = "DOMAIN_NOT_ALLOWED"
+	Unauthorized      Code = "UNAUTHORIZED"
+	RateLimited       Code = "RATE_LIMITED"
+	OriginNotFound    Code = "ORIGIN_NOT_FOUND"
+	OriginError       Code = "ORIGIN_ERROR"
+	OriginTimeout     Code = "ORIGIN_TIMEOUT"
+	TooLarge          Code = "TOO_LARGE"
+	UnsupportedFormat Code = "UNSUPPORTED_FORMAT"
+	Busy              Code = "BUSY"
+	Internal          Code = "INTERNAL"
+)
+
+type Error struct {
+	Status     int    `json:"status"`
+	Code       Code   `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"-"` // seconds, sent as Retry-After when set
+
+	// Per-parameter messages for invalid requests
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Write sends err to the client. Errors that aren't an *Error are reported
+// as internal errors.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = New(http.StatusInternalServerError, Internal, err.Error())
+	}
+
+	h := w.Header()
+	if e.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+	h.Set("X-Error-Code", string(e.Code))
+	h.Add("Vary", "Accept")
+
+	if !acceptsJSON(r.Header.Get("Accept")) {
+		http.Error(w, e.Message, e.Status)
+		return
+	}
+
+	// Like http.Error, a length set for the intended response doesn't apply
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]*Error{"error": e})
+}
+
+// acceptsJSON reports whether the Accept header explicitly lists JSON.
+// Wildcards don't count, browsers send */* for images too.
+func acceptsJSON(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			continue
+		}
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
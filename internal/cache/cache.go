@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMiss is returned by Get when the key isn't cached.
+var ErrMiss = errors.New("cache miss")
+
+type Cache interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry *Entry) error
+	// Purge removes every entry derived from source and reports how many were removed
+	Purge(ctx context.Context, source string) (int, error)
+	// PurgePrefix removes every entry whose source starts with prefix
+	PurgePrefix(ctx context.Context, prefix string) (int, error)
+	Close() error
+}
+
+// Locker is implemented by shared backends that can coordinate work across
+// service instances.
+type Locker interface {
+	// TryLock takes the lock for key if nobody holds it. The lock expires
+	// after ttl even if unlock is never called.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(), acquired bool, err error)
+}
+
+// PurgeBroadcaster is implemented by shared backends that can tell every
+// service instance about purges, so they drop their local copies too.
+type PurgeBroadcaster interface {
+	PublishPurge(ctx context.Context, source string, prefix bool) error
+	// SubscribePurges calls fn for every purge published by any instance,
+	// including this one, until stop is called.
+	SubscribePurges(fn func(source string, prefix bool)) (stop func())
+}
+
+// sourceIndex maps source URLs to the keys of their cached variants for the
+// in-process backends. It is not safe for concurrent use.
+type sourceIndex map[string]map[string]struct{}
+
+func (idx sourceIndex) add(source, key string) {
+	if source == "" {
+		return
+	}
+	if idx[source] == nil {
+		idx[source] = make(map[string]struct{})
+	}
+	idx[source][key] = struct{}{}
+}
+
+func (idx sourceIndex) remove(source, key string) {
+	if keys, ok := idx[source]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(idx, source)
+		}
+	}
+}
+
+// keys returns the variant keys of every source matching match.
+func (idx sourceIndex) keys(match func(source string) bool) []string {
+	var keys []string
+	for source, variants := range idx {
+		if !match(source) {
+			continue
+		}
+		for key := range variants {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
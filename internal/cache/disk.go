@@ -0,0 +1,314 @@
+package cache
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type diskEntry struct {
+	name   string // file name, also the index key
+	source string
+	size   int64
+}
+
+// DiskCache stores entries as files sharded by a hash of the key and evicts
+// the least recently used files once the total size exceeds maxBytes. File
+// modification times track recency so the LRU order survives restarts.
+type DiskCache struct {
+	dir      string
+	mu       sync.Mutex
+	items    map[string]*list.Element
+	order    *list.List // front = most recently used
+	sources  sourceIndex
+	size     int64
+	maxBytes int64
+	ttl      time.Duration
+}
+
+func NewDiskCache(dir string, maxBytes int64, ttl int) (*DiskCache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o755); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		sources:  make(sourceIndex),
+		maxBytes: maxBytes,
+		ttl:      time.Duration(ttl) * time.Second,
+	}
+
+	if err := c.rebuildIndex(); err != nil {
+		return nil, fmt.Errorf("failed to index cache directory: %w", err)
+	}
+
+	return c, nil
+}
+
+// rebuildIndex walks the cache directory, restores the LRU order from file
+// modification times and the source index from the entry headers.
+func (c *DiskCache) rebuildIndex() error {
+	type found struct {
+		name    string
+		source  string
+		size    int64
+		modTime time.Time
+	}
+	var files []found
+
+	// Leftovers from interrupted writes
+	if err := os.RemoveAll(filepath.Join(c.dir, "tmp")); err != nil {
+		return err
+	}
+
+	// Only entries in their xx/yy shard directories are ours. Anything else
+	// may belong to someone else sharing the directory and is left alone.
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+
+		if d.IsDir() {
+			if len(parts) > 2 || !isHex(parts[len(parts)-1], 2) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		name := d.Name()
+		if len(parts) != 3 || !d.Type().IsRegular() || !isHex(name, 64) || name[0:2] != parts[0] || name[2:4] != parts[1] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		meta, err := readDiskMetadata(path)
+		if err != nil {
+			// One of ours, but corrupt
+			os.Remove(path)
+			return nil
+		}
+
+		files = append(files, found{name: name, source: meta.Source, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(c.dir, "tmp"), 0o755); err != nil {
+		return err
+	}
+
+	// Oldest first, so pushing to the front leaves the newest there
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, f := range files {
+		c.items[f.name] = c.order.PushFront(&diskEntry{name: f.name, source: f.source, size: f.size})
+		c.sources.add(f.source, f.name)
+		c.size += f.size
+	}
+	c.evict()
+
+	return nil
+}
+
+func (c *DiskCache) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// isHex reports whether s is n lowercase hex digits, like the names fileName
+// produces and the shard directories derived from them.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *DiskCache) path(name string) string {
+	return filepath.Join(c.dir, name[0:2], name[2:4], name)
+}
+
+func (c *DiskCache) Get(ctx context.Context, key string) (*Entry, error) {
+	name := c.fileName(key)
+
+	c.mu.Lock()
+	elem, ok := c.items[name]
+	if ok {
+		c.order.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, ErrMiss
+	}
+
+	path := c.path(name)
+	var entry *Entry
+	data, err := os.ReadFile(path)
+	if err == nil {
+		entry, err = DecodeEntry(data)
+	}
+	if err != nil {
+		// Removed behind our back or corrupt, either way it's gone
+		c.drop(elem)
+		return nil, ErrMiss
+	}
+
+	if c.ttl > 0 && time.Since(entry.Meta.CreatedAt) > c.ttl {
+		c.drop(elem)
+		return nil, ErrMiss
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return entry, nil
+}
+
+func (c *DiskCache) Set(ctx context.Context, key string, entry *Entry) error {
+	data, err := entry.Encode()
+	if err != nil {
+		return err
+	}
+
+	name := c.fileName(key)
+	path := c.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename so readers never see partial files
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, "tmp"), name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(data))
+
+	// Renamed under the lock, so the file and the index always agree
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if elem, ok := c.items[name]; ok {
+		c.unindex(elem)
+	}
+	c.items[name] = c.order.PushFront(&diskEntry{name: name, source: entry.Meta.Source, size: size})
+	c.sources.add(entry.Meta.Source, name)
+	c.size += size
+	c.evict()
+
+	return nil
+}
+
+// evict removes least recently used files until the cache fits. Callers hold c.mu.
+func (c *DiskCache) evict() {
+	for c.size > c.maxBytes && c.order.Len() > 0 {
+		entry := c.unindex(c.order.Back())
+		os.Remove(c.path(entry.name))
+	}
+}
+
+// unindex drops elem from the in-memory index. Callers hold c.mu.
+func (c *DiskCache) unindex(elem *list.Element) *diskEntry {
+	entry := c.order.Remove(elem).(*diskEntry)
+	delete(c.items, entry.name)
+	c.sources.remove(entry.source, entry.name)
+	c.size -= entry.size
+	return entry
+}
+
+// drop removes the entry elem was looked up as, unless a concurrent Set
+// has replaced it in the meantime.
+func (c *DiskCache) drop(elem *list.Element) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := elem.Value.(*diskEntry).name
+	if c.items[name] != elem {
+		return
+	}
+	c.unindex(elem)
+	os.Remove(c.path(name))
+}
+
+func (c *DiskCache) Purge(ctx context.Context, source string) (int, error) {
+	return c.purge(func(s string) bool { return s == source })
+}
+
+func (c *DiskCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return c.purge(func(s string) bool { return strings.HasPrefix(s, prefix) })
+}
+
+func (c *DiskCache) purge(match func(source string) bool) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := c.sources.keys(match)
+	var firstErr error
+	for _, name := range names {
+		c.unindex(c.items[name])
+		if err := os.Remove(c.path(name)); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return len(names), firstErr
+}
+
+func readDiskMetadata(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return DecodeMetadata(bufio.NewReader(f))
+}
+
+func (c *DiskCache) Close() error {
+	return nil
+}
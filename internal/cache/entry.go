@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// Metadata describes a cached image so hits can be served without
+// re-inspecting the bytes.
+type Metadata struct {
+	Source       string    `json:"source,omitempty"` // source image URL, used to purge its variants
+	ContentType  string    `json:"content_type"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified,omitzero"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type Entry struct {
+	Meta Metadata
+	Data []byte
+}
+
+// Serialized entries start with a magic prefix so values written by older
+// versions (raw image bytes) are treated as misses instead of garbage.
+var entryMagic = []byte("IMC1")
+
+var errCorruptEntry = errors.New("corrupt cache entry")
+
+// Metadata headers are a few hundred bytes, anything bigger is corruption
+const maxHeaderSize = 64 * 1024
+
+// Encode serializes the entry as magic, a 4-byte header length, the JSON
+// metadata header and the image bytes.
+func (e *Entry) Encode() ([]byte, error) {
+	header, err := json.Marshal(e.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(entryMagic)+4+len(header)+len(e.Data))
+	buf = append(buf, entryMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(header)))
+	buf = append(buf, header...)
+	buf = append(buf, e.Data...)
+	return buf, nil
+}
+
+// DecodeMetadata reads only the metadata header of a serialized entry.
+func DecodeMetadata(r io.Reader) (*Metadata, error) {
+	prefix := make([]byte, len(entryMagic)+4)
+	if _, err := io.ReadFull(r, prefix); err != nil || !bytes.HasPrefix(prefix, entryMagic) {
+		return nil, errCorruptEntry
+	}
+
+	headerLen := binary.BigEndian.Uint32(prefix[len(entryMagic):])
+	if headerLen > maxHeaderSize {
+		return nil, errCorruptEntry
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errCorruptEntry
+	}
+
+	var meta Metadata
+	if err := json.Unmarshal(header, &meta); err != nil {
+		return nil, errCorruptEntry
+	}
+	return &meta, nil
+}
+
+func DecodeEntry(data []byte) (*Entry, error) {
+	if !bytes.HasPrefix(data, entryMagic) {
+		return nil, errCorruptEntry
+	}
+	data = data[len(entryMagic):]
+
+	if len(data) < 4 {
+		return nil, errCorruptEntry
+	}
+	headerLen := int(binary.BigEndian.Uint32(data[:4]))
+	if len(data) < 4+headerLen {
+		return nil, errCorruptEntry
+	}
+
+	entry := &Entry{Data: data[4+headerLen:]}
+	if err := json.Unmarshal(data[4:4+headerLen], &entry.Meta); err != nil {
+		return nil, errCorruptEntry
+	}
+
+	return entry, nil
+}
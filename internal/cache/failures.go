@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Failures remembers failed origin fetches by source URL, so requests for a
+// broken image are answered without asking the origin again until the
+// backing cache's TTL expires.
+type Failures struct {
+	cache Cache
+}
+
+type Failure struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewFailures(c Cache) *Failures {
+	return &Failures{cache: c}
+}
+
+func failureKey(sourceURL string) string {
+	sum := sha256.Sum256([]byte(sourceURL))
+	return "fail:" + hex.EncodeToString(sum[:])
+}
+
+func (f *Failures) Get(ctx context.Context, sourceURL string) (*Failure, error) {
+	entry, err := f.cache.Get(ctx, failureKey(sourceURL))
+	if err != nil {
+		return nil, err
+	}
+
+	var failure Failure
+	if err := json.Unmarshal(entry.Data, &failure); err != nil {
+		return nil, ErrMiss
+	}
+	return &failure, nil
+}
+
+func (f *Failures) Set(ctx context.Context, sourceURL string, failure *Failure) error {
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+
+	return f.cache.Set(ctx, failureKey(sourceURL), &Entry{
+		Meta: Metadata{
+			Source:      sourceURL,
+			ContentType: "application/json",
+			CreatedAt:   time.Now(),
+		},
+		Data: data,
+	})
+}
+
+func (f *Failures) Purge(ctx context.Context, sourceURL string) (int, error) {
+	return f.cache.Purge(ctx, sourceURL)
+}
+
+func (f *Failures) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return f.cache.PurgePrefix(ctx, prefix)
+}
+
+func (f *Failures) Close() error {
+	return f.cache.Close()
+}
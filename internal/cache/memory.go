@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	key       string
+	entry     *Entry
+	expiresAt time.Time
+}
+
+// MemoryCache is an in-process LRU bounded by the total size of the cached images.
+type MemoryCache struct {
+	mu       sync.Mutex
+	items    map[string]*list.Element
+	order    *list.List // front = most recently used
+	sources  sourceIndex
+	size     int64
+	maxBytes int64
+	ttl      time.Duration
+}
+
+func NewMemoryCache(maxBytes int64, ttl int) *MemoryCache {
+	return &MemoryCache{
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		sources:  make(sourceIndex),
+		maxBytes: maxBytes,
+		ttl:      time.Duration(ttl) * time.Second,
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) (*Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+
+	item := elem.Value.(*memoryItem)
+	if !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
+		c.remove(elem)
+		return nil, ErrMiss
+	}
+
+	c.order.MoveToFront(elem)
+	return item.entry, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, entry *Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Images larger than the whole cache would only evict everything else
+	if int64(len(entry.Data)) > c.maxBytes {
+		return nil
+	}
+
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+
+	// Expiry counts from when the entry was made, not when it got here, so
+	// copies from another tier don't outlive the original
+	item := &memoryItem{key: key, entry: entry}
+	if c.ttl > 0 {
+		created := entry.Meta.CreatedAt
+		if created.IsZero() {
+			created = time.Now()
+		}
+		item.expiresAt = created.Add(c.ttl)
+		if time.Now().After(item.expiresAt) {
+			return nil
+		}
+	}
+	c.items[key] = c.order.PushFront(item)
+	c.sources.add(entry.Meta.Source, key)
+	c.size += int64(len(entry.Data))
+
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+
+	return nil
+}
+
+func (c *MemoryCache) remove(elem *list.Element) {
+	item := c.order.Remove(elem).(*memoryItem)
+	delete(c.items, item.key)
+	c.sources.remove(item.entry.Meta.Source, item.key)
+	c.size -= int64(len(item.entry.Data))
+}
+
+func (c *MemoryCache) Purge(ctx context.Context, source string) (int, error) {
+	return c.purge(func(s string) bool { return s == source }), nil
+}
+
+func (c *MemoryCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return c.purge(func(s string) bool { return strings.HasPrefix(s, prefix) }), nil
+}
+
+func (c *MemoryCache) purge(match func(source string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.sources.keys(match)
+	for _, key := range keys {
+		c.remove(c.items[key])
+	}
+	return len(keys)
+}
+
+func (c *MemoryCache) Close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Originals caches downloaded source images by URL, so generating several
+// variants of one image downloads it once. Entries older than maxAge are
+// stale: still returned, but the caller should revalidate them with the
+// origin using their ETag and LastModified.
+type Originals struct {
+	cache  Cache
+	maxAge time.Duration
+}
+
+func NewOriginals(c Cache, maxAge time.Duration) *Originals {
+	// Sharing Redis with the variants, keep a separate source index
+	if r, ok := c.(*RedisCache); ok {
+		r.indexPrefix = originalsIndexPrefix
+	}
+
+	return &Originals{
+		cache:  c,
+		maxAge: maxAge,
+	}
+}
+
+func originalKey(sourceURL string) string {
+	sum := sha256.Sum256([]byte(sourceURL))
+	return "orig:" + hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached original of sourceURL and whether it is still fresh.
+func (o *Originals) Get(ctx context.Context, sourceURL string) (*Entry, bool, error) {
+	entry, err := o.cache.Get(ctx, originalKey(sourceURL))
+	if err != nil {
+		return nil, false, err
+	}
+	return entry, time.Since(entry.Meta.CreatedAt) < o.maxAge, nil
+}
+
+func (o *Originals) Set(ctx context.Context, sourceURL string, entry *Entry) error {
+	return o.cache.Set(ctx, originalKey(sourceURL), entry)
+}
+
+func (o *Originals) Purge(ctx context.Context, sourceURL string) (int, error) {
+	return o.cache.Purge(ctx, sourceURL)
+}
+
+func (o *Originals) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return o.cache.PurgePrefix(ctx, prefix)
+}
+
+func (o *Originals) Close() error {
+	return o.cache.Close()
+}
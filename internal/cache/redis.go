@@ -2,17 +2,40 @@ package cache
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-type Cache struct {
-	client *redis.Client
-	ttl    time.Duration
+// Source index sets hold the variant keys derived from one source URL.
+// Originals have their own, so the two caches' TTLs don't cut each other's
+// index short.
+const (
+	sourceIndexPrefix    = "src:"
+	originalsIndexPrefix = "src-orig:"
+)
+
+const lockPrefix = "lock:"
+
+// Purges are announced here for the local tiers of every instance
+const purgeChannel = "purge"
+
+type purgeMessage struct {
+	Source string `json:"source"`
+	Prefix bool   `json:"prefix"`
 }
 
-func NewCache(redisURL, password string, ttl int) (*Cache, error) {
+type RedisCache struct {
+	client      *redis.Client
+	ttl         time.Duration
+	indexPrefix string
+}
+
+func NewRedisCache(redisURL, password string, ttl int) (*RedisCache, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		opts = &redis.Options{
@@ -38,20 +61,158 @@ func NewCache(redisURL, password string, ttl int) (*Cache, error) {
 		return nil, err
 	}
 
-	return &Cache{
-		client: client,
-		ttl:    time.Duration(ttl) * time.Second,
+	return &RedisCache{
+		client:      client,
+		ttl:         time.Duration(ttl) * time.Second,
+		indexPrefix: sourceIndexPrefix,
 	}, nil
 }
 
-func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
-	return c.client.Get(ctx, key).Bytes()
+func (c *RedisCache) Get(ctx context.Context, key string) (*Entry, error) {
+	data, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := DecodeEntry(data)
+	if err != nil {
+		return nil, ErrMiss
+	}
+	return entry, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, entry *Entry) error {
+	data, err := entry.Encode()
+	if err != nil {
+		return err
+	}
+
+	if entry.Meta.Source == "" {
+		return c.client.Set(ctx, key, data, c.ttl).Err()
+	}
+
+	// Track the variant under its source so Purge can find it. The set lives
+	// as long as its newest variant.
+	indexKey := c.indexPrefix + entry.Meta.Source
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, data, c.ttl)
+		pipe.SAdd(ctx, indexKey, key)
+		if c.ttl > 0 {
+			pipe.Expire(ctx, indexKey, c.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (c *RedisCache) Purge(ctx context.Context, source string) (int, error) {
+	return c.purgeIndex(ctx, c.indexPrefix+source)
+}
+
+func (c *RedisCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	purged := 0
+	pattern := c.indexPrefix + escapeGlob(prefix) + "*"
+
+	iter := c.client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		n, err := c.purgeIndex(ctx, iter.Val())
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, iter.Err()
+}
+
+// purgeIndex deletes the variants listed in a source index set and the set itself.
+func (c *RedisCache) purgeIndex(ctx context.Context, indexKey string) (int, error) {
+	keys, err := c.client.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	if len(keys) > 0 {
+		n, err := c.client.Del(ctx, keys...).Result()
+		if err != nil {
+			return 0, err
+		}
+		purged = int(n)
+	}
+
+	return purged, c.client.Del(ctx, indexKey).Err()
+}
+
+func (c *RedisCache) PublishPurge(ctx context.Context, source string, prefix bool) error {
+	data, err := json.Marshal(purgeMessage{Source: source, Prefix: prefix})
+	if err != nil {
+		return err
+	}
+	return c.client.Publish(ctx, purgeChannel, data).Err()
+}
+
+func (c *RedisCache) SubscribePurges(fn func(source string, prefix bool)) func() {
+	sub := c.client.Subscribe(context.Background(), purgeChannel)
+
+	go func() {
+		// The channel is closed, and the loop ends, when sub is closed
+		for msg := range sub.Channel() {
+			var purge purgeMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &purge); err != nil {
+				continue
+			}
+			fn(purge.Source, purge.Prefix)
+		}
+	}()
+
+	return func() { sub.Close() }
+}
+
+// Deletes the lock only if it still holds our token, so an expired lock
+// taken over by another instance isn't released by us
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (c *RedisCache) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	lockKey := lockPrefix + key
+
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, false, err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	acquired, err := c.client.SetNX(ctx, lockKey, token, ttl).Result()
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+
+	unlock := func() {
+		unlockScript.Run(context.Background(), c.client, []string{lockKey}, token)
+	}
+	return unlock, true, nil
 }
 
-func (c *Cache) Set(ctx context.Context, key string, value []byte) error {
-	return c.client.Set(ctx, key, value, c.ttl).Err()
+// escapeGlob escapes the characters SCAN MATCH treats specially.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '^', '-', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
 
-func (c *Cache) Close() error {
+func (c *RedisCache) Close() error {
 	return c.client.Close()
 }
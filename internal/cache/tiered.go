@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// TieredCache serves from a fast front cache (usually memory) and falls back
+// to a shared back cache (usually Redis), copying back hits to the front.
+// When the back tier broadcasts purges, every instance clears its front.
+type TieredCache struct {
+	front       Cache
+	back        Cache
+	unsubscribe func()
+}
+
+func NewTieredCache(front, back Cache) *TieredCache {
+	c := &TieredCache{
+		front: front,
+		back:  back,
+	}
+
+	if broadcaster, ok := back.(PurgeBroadcaster); ok {
+		c.unsubscribe = broadcaster.SubscribePurges(func(source string, prefix bool) {
+			ctx := context.Background()
+			if prefix {
+				front.PurgePrefix(ctx, source)
+			} else {
+				front.Purge(ctx, source)
+			}
+		})
+	}
+
+	return c
+}
+
+func (c *TieredCache) Get(ctx context.Context, key string) (*Entry, error) {
+	if entry, err := c.front.Get(ctx, key); err == nil {
+		return entry, nil
+	}
+
+	entry, err := c.back.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.front.Set(ctx, key, entry)
+	return entry, nil
+}
+
+func (c *TieredCache) Set(ctx context.Context, key string, entry *Entry) error {
+	if err := c.front.Set(ctx, key, entry); err != nil {
+		return err
+	}
+	return c.back.Set(ctx, key, entry)
+}
+
+func (c *TieredCache) Purge(ctx context.Context, source string) (int, error) {
+	return c.purge(ctx, source, false, func(tier Cache) (int, error) { return tier.Purge(ctx, source) })
+}
+
+func (c *TieredCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return c.purge(ctx, prefix, true, func(tier Cache) (int, error) { return tier.PurgePrefix(ctx, prefix) })
+}
+
+// purge clears both tiers, then tells the other instances to clear their
+// fronts. The back goes first so nobody copies a purged entry back into
+// their front in between. The front usually holds a subset of the back, so
+// the larger count is the number of distinct entries removed.
+func (c *TieredCache) purge(ctx context.Context, source string, prefix bool, purgeTier func(tier Cache) (int, error)) (int, error) {
+	back, err := purgeTier(c.back)
+	if err != nil {
+		return back, err
+	}
+
+	front, err := purgeTier(c.front)
+	if err != nil {
+		return max(front, back), err
+	}
+
+	if broadcaster, ok := c.back.(PurgeBroadcaster); ok {
+		err = broadcaster.PublishPurge(ctx, source, prefix)
+	}
+	return max(front, back), err
+}
+
+// TryLock locks in the back tier when it supports locking. Otherwise there
+// is nothing shared to coordinate through and the lock is always granted.
+func (c *TieredCache) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	if locker, ok := c.back.(Locker); ok {
+		return locker.TryLock(ctx, key, ttl)
+	}
+	return func() {}, true, nil
+}
+
+func (c *TieredCache) Close() error {
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+	}
+
+	frontErr := c.front.Close()
+	if err := c.back.Close(); err != nil {
+		return err
+	}
+	return frontErr
+}
@@ -0,0 +1,91 @@
+// Package coalesce deduplicates concurrent calls that share a key, so that
+// only one of them does the work and the rest wait for its result.
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Waiters see this if the call panicked instead of returning
+var errPanicked = errors.New("coalesced call panicked")
+
+type call[T any] struct {
+	done    chan struct{}
+	val     T
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+// Do runs fn once per key at a time. Callers arriving while fn is running
+// block until it returns and receive the same result, with shared set.
+//
+// A caller whose ctx is done stops waiting and gets ctx's error. fn's own
+// context keeps ctx's values but is only cancelled once every caller has
+// given up, so nobody loses work someone else is still waiting for.
+func (g *Group[T]) Do(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (val T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		c.waiters++
+		g.mu.Unlock()
+		return g.wait(ctx, key, c, true)
+	}
+
+	callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c := &call[T]{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.err = fmt.Errorf("%w: %v", errPanicked, r)
+			}
+
+			g.mu.Lock()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(c.done)
+		}()
+
+		c.val, c.err = fn(callCtx)
+	}()
+
+	return g.wait(ctx, key, c, false)
+}
+
+func (g *Group[T]) wait(ctx context.Context, key string, c *call[T], shared bool) (T, error, bool) {
+	select {
+	case <-c.done:
+		return c.val, c.err, shared
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	c.waiters--
+	if c.waiters == 0 {
+		c.cancel()
+		// Callers arriving now start over instead of joining a cancelled call
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+	}
+	g.mu.Unlock()
+
+	var zero T
+	return zero, ctx.Err(), shared
+}
@@ -0,0 +1,190 @@
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoRunsOnceForConcurrentCallers(t *testing.T) {
+	var g Group[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	const n = 50
+	var started, done sync.WaitGroup
+	started.Add(n)
+	done.Add(n)
+	results := make([]int, n)
+	shared := make([]bool, n)
+
+	for i := range n {
+		go func() {
+			defer done.Done()
+			started.Done()
+			var err error
+			results[i], err, shared[i] = g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+				calls.Add(1)
+				<-release
+				return 42, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	started.Wait()
+	// Let every caller join the running call before it returns
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("fn ran %d times, want 1", got)
+	}
+	sharedCount := 0
+	for i := range n {
+		if results[i] != 42 {
+			t.Errorf("caller %d got %d, want 42", i, results[i])
+		}
+		if shared[i] {
+			sharedCount++
+		}
+	}
+	if sharedCount != n-1 {
+		t.Errorf("%d callers shared the result, want %d", sharedCount, n-1)
+	}
+}
+
+func TestDoCancelsWhenLastWaiterLeaves(t *testing.T) {
+	var g Group[int]
+	fnCancelled := make(chan struct{})
+	running := make(chan struct{})
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err, _ := g.Do(ctx1, "key", func(ctx context.Context) (int, error) {
+			close(running)
+			<-ctx.Done()
+			close(fnCancelled)
+			return 0, ctx.Err()
+		})
+		errs <- err
+	}()
+	<-running
+	go func() {
+		_, err, _ := g.Do(ctx2, "key", func(ctx context.Context) (int, error) {
+			t.Error("second caller ran fn instead of joining")
+			return 0, nil
+		})
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller err = %v, want context.Canceled", err)
+	}
+	select {
+	case <-fnCancelled:
+		t.Fatal("fn was cancelled while a caller was still waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel2()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("second caller err = %v, want context.Canceled", err)
+	}
+	select {
+	case <-fnCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("fn wasn't cancelled after every caller left")
+	}
+}
+
+func TestDoEarlyLeaverDoesNotCancelOthers(t *testing.T) {
+	var g Group[int]
+	release := make(chan struct{})
+	running := make(chan struct{})
+
+	leaverCtx, leave := context.WithCancel(context.Background())
+
+	result := make(chan int, 1)
+	go func() {
+		v, err, _ := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+			close(running)
+			select {
+			case <-release:
+				return 7, nil
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		result <- v
+	}()
+	<-running
+
+	left := make(chan error, 1)
+	go func() {
+		_, err, _ := g.Do(leaverCtx, "key", nil)
+		left <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	leave()
+	if err := <-left; !errors.Is(err, context.Canceled) {
+		t.Errorf("leaver err = %v, want context.Canceled", err)
+	}
+
+	close(release)
+	if v := <-result; v != 7 {
+		t.Errorf("remaining caller got %d, want 7", v)
+	}
+}
+
+func TestDoPanicReachesAllWaiters(t *testing.T) {
+	var g Group[int]
+	release := make(chan struct{})
+	running := make(chan struct{})
+
+	const n = 5
+	errs := make(chan error, n)
+	go func() {
+		_, err, _ := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+			close(running)
+			<-release
+			panic("boom")
+		})
+		errs <- err
+	}()
+	<-running
+	for range n - 1 {
+		go func() {
+			_, err, _ := g.Do(context.Background(), "key", nil)
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	for range n {
+		if err := <-errs; !errors.Is(err, errPanicked) {
+			t.Errorf("err = %v, want errPanicked", err)
+		}
+	}
+
+	// The key is free again afterwards
+	v, err, _ := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) { return 1, nil })
+	if err != nil || v != 1 {
+		t.Errorf("after panic: %d, %v", v, err)
+	}
+}
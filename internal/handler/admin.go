@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"image-service/internal/apierror"
+)
+
+// PurgeCache drops every cached variant of ?url=<source>, or of every source
+// starting with ?prefix=<prefix>, along with cached originals and failures.
+func (h *Handler) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	source := query.Get("url")
+	prefix := query.Get("prefix")
+
+	var purged int
+	var err error
+	switch {
+	case source != "" && prefix != "":
+		apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Use either url or prefix, not both"))
+		return
+	case source != "":
+		purged, err = h.purge(func(c purger) (int, error) { return c.Purge(ctx, source) })
+	case prefix != "":
+		purged, err = h.purge(func(c purger) (int, error) { return c.PurgePrefix(ctx, prefix) })
+	default:
+		apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Missing url or prefix parameter"))
+		return
+	}
+
+	if err != nil {
+		apierror.Write(w, r, fmt.Errorf("Failed to purge cache: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"purged": purged,
+	})
+}
+
+type purger interface {
+	Purge(ctx context.Context, source string) (int, error)
+	PurgePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// purge runs purgeOne against the variant cache and every enabled cache of
+// originals and failures, summing the removed entries.
+func (h *Handler) purge(purgeOne func(c purger) (int, error)) (int, error) {
+	caches := []purger{h.cache}
+	if h.originals != nil {
+		caches = append(caches, h.originals)
+	}
+	if h.failures != nil {
+		caches = append(caches, h.failures)
+	}
+
+	total := 0
+	for _, c := range caches {
+		n, err := purgeOne(c)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
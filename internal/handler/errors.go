@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"image-service/internal/apierror"
+	"image-service/internal/processor"
+	"image-service/internal/source"
+)
+
+// errBusy sheds load when the processor's queue is full or too slow
+var errBusy = &apierror.Error{
+	Status:     http.StatusServiceUnavailable,
+	Code:       apierror.Busy,
+	Message:    "Server busy, try again later",
+	RetryAfter: 5,
+}
+
+// invalidParam reports a bad request, keeping the per-field details of
+// errors from parseTransformOptions.
+func invalidParam(err error) error {
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	return apierror.New(http.StatusBadRequest, apierror.InvalidParam, err.Error())
+}
+
+// downloadError reports a failed download as a bad gateway unless the
+// source says why.
+func downloadError(err error) error {
+	var apiErr *apierror.Error
+	switch {
+	case errors.As(err, &apiErr):
+		return err
+	case errors.Is(err, context.DeadlineExceeded):
+		return apierror.New(http.StatusGatewayTimeout, apierror.OriginTimeout, "Timed out downloading image")
+	case errors.Is(err, source.ErrTooLarge):
+		// The origin's image is too large, not the request: 413 is for upload bodies
+		return apierror.New(http.StatusUnprocessableEntity, apierror.TooLarge, "Image too large")
+	case errors.Is(err, source.ErrNotAllowed):
+		return apierror.New(http.StatusForbidden, apierror.DomainNotAllowed, "Domain not allowed")
+	case errors.Is(err, source.ErrNotFound):
+		return apierror.New(http.StatusNotFound, apierror.OriginNotFound, "Image not found")
+	}
+	return apierror.New(http.StatusBadGateway, apierror.OriginError, fmt.Sprintf("Failed to download image: %v", err))
+}
+
+// processError reports why the processor couldn't handle an image. Only
+// failures that aren't down to the image or the request are internal errors.
+func processError(err error) error {
+	switch {
+	case errors.Is(err, processor.ErrQueueFull), errors.Is(err, processor.ErrQueueTimeout):
+		return errBusy
+	case errors.Is(err, processor.ErrTooManyPixels), errors.Is(err, processor.ErrOutputTooLarge):
+		return apierror.New(http.StatusUnprocessableEntity, apierror.TooLarge, err.Error())
+	case errors.Is(err, processor.ErrInvalidOption):
+		return apierror.New(http.StatusBadRequest, apierror.InvalidParam, err.Error())
+	case errors.Is(err, processor.ErrUnsupportedFormat):
+		return apierror.New(http.StatusUnsupportedMediaType, apierror.UnsupportedFormat, err.Error())
+	}
+	return apierror.New(http.StatusInternalServerError, apierror.Internal, fmt.Sprintf("Failed to process image: %v", err))
+}
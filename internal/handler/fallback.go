@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"image-service/internal/apierror"
+	"image-service/internal/cache"
+	"image-service/internal/processor"
+)
+
+// fallback is an image served in place of one that can't be produced.
+type fallback struct {
+	data []byte
+	// Content hash, so variants cached from an older file aren't reused
+	id string
+}
+
+func loadFallback(path string) (*fallback, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fallback image: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return &fallback{data: data, id: hex.EncodeToString(sum[:8])}, nil
+}
+
+// Failures the fallback image stands in for: the source is missing,
+// unreachable, too large or not a decodable image. Bad requests and load
+// shedding are still reported as they are.
+var fallbackCodes = map[apierror.Code]bool{
+	apierror.OriginNotFound:    true,
+	apierror.OriginError:       true,
+	apierror.OriginTimeout:     true,
+	apierror.TooLarge:          true,
+	apierror.UnsupportedFormat: true,
+}
+
+// writeFallback answers a failed transform with the preset's fallback image,
+// or the global one, transformed with the requested options. The response
+// keeps the failure's status, has its code in X-Fallback and is only cached
+// briefly downstream. It reports false when there is nothing to fall back to.
+func (h *Handler) writeFallback(w http.ResponseWriter, r *http.Request, failure error, preset string, opts processor.TransformOptions) bool {
+	var apiErr *apierror.Error
+	if !errors.As(failure, &apiErr) || !fallbackCodes[apiErr.Code] {
+		return false
+	}
+
+	fb := h.fallbacks[preset]
+	if fb == nil {
+		fb = h.fallback
+	}
+	if fb == nil {
+		return false
+	}
+
+	// Indexed under its own source, so purging "fallback:" clears the variants
+	source := "fallback:" + fb.id
+	cacheKey := h.generateCacheKey(source, opts)
+	entry, err := h.cache.Get(r.Context(), cacheKey)
+	if err != nil {
+		entry, err, _ = h.inflight.Do(r.Context(), cacheKey, func(ctx context.Context) (*cache.Entry, error) {
+			entry, err := h.render(ctx, fb.data, opts, source, time.Time{})
+			if err != nil {
+				return nil, err
+			}
+			h.store(cacheKey, entry)
+			return entry, nil
+		})
+		if err != nil {
+			return false
+		}
+	}
+
+	w.Header().Set("Content-Type", entry.Meta.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.Data)))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", h.fallbackMaxAge))
+	w.Header().Set("X-Fallback", string(apiErr.Code))
+	w.WriteHeader(apiErr.Status)
+	w.Write(entry.Data)
+	return true
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"image-service/internal/apierror"
+)
+
+// Info reports the source image's properties as JSON without transforming it.
+func (h *Handler) Info(w http.ResponseWriter, r *http.Request) {
+	imageURL := r.URL.Query().Get("url")
+
+	image, err := h.original(r.Context(), imageURL, h.forwardedHeaders(imageURL, r.Header))
+	if err != nil {
+		apierror.Write(w, r, downloadError(err))
+		return
+	}
+
+	info, err := h.processor.Info(r.Context(), image.Data)
+	if err != nil {
+		apierror.Write(w, r, processError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Metrics exposes processing queue statistics in the Prometheus text format.
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	stats := h.processor.Stats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metrics := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"image_transforms_active", "gauge", "Images being processed right now.", stats.Active},
+		{"image_transforms_queued", "gauge", "Requests waiting for a processing slot.", stats.Queued},
+		{"image_transforms_max_concurrent", "gauge", "Maximum images processed at once.", int64(stats.MaxConcurrent)},
+		{"image_transforms_queue_size", "gauge", "Maximum requests waiting for a processing slot.", int64(stats.QueueSize)},
+		{"image_transforms_processed_total", "counter", "Images processed.", stats.Processed},
+		{"image_transforms_rejected_total", "counter", "Requests rejected because the queue was full.", stats.Rejected},
+		{"image_transforms_timed_out_total", "counter", "Requests that gave up waiting for a processing slot.", stats.TimedOut},
+	}
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
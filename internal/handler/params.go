@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"image-service/internal/apierror"
+	"image-service/internal/processor"
+)
+
+// Transform parameters, with the values they are normalized to. Each
+// parameter's parser lives in parseTransformOptions.
+var (
+	fits = map[string]string{
+		"cover":     "cover",
+		"contain":   "contain",
+		"fill":      "fill",
+		"attention": "attention",
+	}
+	formats = map[string]string{
+		"jpeg": "jpeg",
+		"jpg":  "jpeg",
+		"webp": "webp",
+		"avif": "avif",
+		"png":  "png",
+		"auto": "auto",
+	}
+	flips = map[string]string{
+		"h":    "h",
+		"v":    "v",
+		"both": "both",
+		"hv":   "both",
+		"vh":   "both",
+	}
+	transformParams = map[string]bool{
+		"w": true, "h": true, "fit": true, "f": true, "q": true, "crop": true,
+		"blur": true, "sharpen": true, "brightness": true, "contrast": true,
+		"saturation": true, "auto": true, "grayscale": true, "bw": true,
+		"flip": true, "rotate": true, "bg": true, "strip": true,
+	}
+)
+
+// parseTransformOptions validates the transform parameters in query and
+// normalizes them, so equivalent requests share a cache key. All invalid
+// parameters are reported at once. In strict mode unknown parameters are
+// rejected too, instead of being ignored.
+//
+// w and h have no upper bound here, the processor answers requests past
+// MAX_OUTPUT_DIMENSION with 422 TOO_LARGE.
+func parseTransformOptions(query url.Values, strict bool) (string, processor.TransformOptions, error) {
+	p := &paramParser{query: query}
+
+	grayscale := p.bool("grayscale", false)
+	bw := p.bool("bw", false)
+
+	opts := processor.TransformOptions{
+		Width:      p.int("w", 0, 0, math.MaxInt32),
+		Height:     p.int("h", 0, 0, math.MaxInt32),
+		Fit:        p.enum("fit", "cover", fits),
+		Format:     p.enum("f", "jpeg", formats),
+		Quality:    p.int("q", 80, 1, 100),
+		Crop:       p.crop("crop"),
+		Blur:       p.int("blur", 0, 0, 100),
+		Sharpen:    p.float("sharpen", 0, 0, 10),
+		Brightness: p.float("brightness", 0, -100, 100),
+		Contrast:   p.float("contrast", 1, 0.5, 2),
+		Saturation: p.float("saturation", 1, 0, 3),
+		AutoOptim:  p.bool("auto", false),
+		Grayscale:  grayscale || bw,
+		Flip:       p.enum("flip", "", flips),
+		Rotate:     p.rotate("rotate"),
+		Background: p.color("bg"),
+		Strip:      p.bool("strip", true),
+	}
+
+	// The fit mode only matters when resizing
+	if opts.Width == 0 && opts.Height == 0 {
+		opts.Fit = "cover"
+	}
+
+	if strict {
+		for key := range query {
+			if !transformParams[key] && !nonTransformParams[key] {
+				p.fail(key, "unknown parameter")
+			}
+		}
+	}
+
+	if err := p.err(); err != nil {
+		return "", processor.TransformOptions{}, err
+	}
+	return query.Get("url"), opts, nil
+}
+
+// paramParser reads typed parameters from a query, collecting a message
+// for each one that is invalid. Missing and empty parameters get defaults.
+type paramParser struct {
+	query  url.Values
+	errors map[string]string
+}
+
+func (p *paramParser) fail(key, format string, args ...any) {
+	if p.errors == nil {
+		p.errors = map[string]string{}
+	}
+	p.errors[key] = fmt.Sprintf(format, args...)
+}
+
+func (p *paramParser) err() error {
+	if len(p.errors) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(p.errors))
+	for key := range p.errors {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	messages := make([]string, len(keys))
+	for i, key := range keys {
+		messages[i] = key + ": " + p.errors[key]
+	}
+
+	return &apierror.Error{
+		Status:  http.StatusBadRequest,
+		Code:    apierror.InvalidParam,
+		Message: "Invalid parameters: " + strings.Join(messages, "; "),
+		Fields:  p.errors,
+	}
+}
+
+func (p *paramParser) int(key string, def, min, max int) int {
+	value := strings.TrimSpace(p.query.Get(key))
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		p.fail(key, "must be an integer")
+		return def
+	}
+	if n < min || n > max {
+		p.outOfRange(key, float64(min), float64(max), max == math.MaxInt32)
+		return def
+	}
+	return n
+}
+
+func (p *paramParser) float(key string, def, min, max float64) float64 {
+	value := strings.TrimSpace(p.query.Get(key))
+	if value == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) {
+		p.fail(key, "must be a number")
+		return def
+	}
+	if f < min || f > max {
+		p.outOfRange(key, min, max, false)
+		return def
+	}
+	// Cache keys keep two decimals, finer differences would share a variant
+	return math.Round(f*100) / 100
+}
+
+func (p *paramParser) outOfRange(key string, min, max float64, unbounded bool) {
+	if unbounded {
+		p.fail(key, "must be at least %g", min)
+		return
+	}
+	p.fail(key, "must be between %g and %g", min, max)
+}
+
+func (p *paramParser) bool(key string, def bool) bool {
+	value := strings.TrimSpace(p.query.Get(key))
+	if value == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(key, "must be true or false")
+		return def
+	}
+	return b
+}
+
+func (p *paramParser) enum(key, def string, values map[string]string) string {
+	value := strings.ToLower(strings.TrimSpace(p.query.Get(key)))
+	if value == "" {
+		return def
+	}
+
+	normalized, ok := values[value]
+	if !ok {
+		allowed := make([]string, 0, len(values))
+		for name, target := range values {
+			// Aliases aren't advertised
+			if name == target {
+				allowed = append(allowed, name)
+			}
+		}
+		slices.Sort(allowed)
+		p.fail(key, "must be one of %s", strings.Join(allowed, ", "))
+		return def
+	}
+	return normalized
+}
+
+// crop parses "x,y,width,height" with an origin inside the image and a
+// non-empty area. Whether the area fits is only known once it is loaded.
+func (p *paramParser) crop(key string) string {
+	value := strings.TrimSpace(p.query.Get(key))
+	if value == "" {
+		return ""
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		p.fail(key, "must be x,y,width,height")
+		return ""
+	}
+
+	area := make([]int, 4)
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			p.fail(key, "must be x,y,width,height")
+			return ""
+		}
+		area[i] = n
+	}
+	if area[0] < 0 || area[1] < 0 || area[2] <= 0 || area[3] <= 0 {
+		p.fail(key, "must have a non-negative origin and a positive size")
+		return ""
+	}
+
+	return fmt.Sprintf("%d,%d,%d,%d", area[0], area[1], area[2], area[3])
+}
+
+// rotate accepts any multiple of 90 degrees, normalized to 0-270.
+func (p *paramParser) rotate(key string) int {
+	value := strings.TrimSpace(p.query.Get(key))
+	if value == "" {
+		return 0
+	}
+
+	degrees, err := strconv.Atoi(value)
+	if err != nil || degrees%90 != 0 {
+		p.fail(key, "must be a multiple of 90")
+		return 0
+	}
+	return (degrees%360 + 360) % 360
+}
+
+// color accepts 3 or 6 digit hex colors with or without a leading '#',
+// normalized to 6 lowercase digits.
+func (p *paramParser) color(key string) string {
+	value := strings.TrimPrefix(strings.TrimSpace(p.query.Get(key)), "#")
+	if value == "" {
+		return ""
+	}
+
+	if len(value) != 3 && len(value) != 6 {
+		p.fail(key, "must be a hex color like ffffff")
+		return ""
+	}
+	for _, c := range value {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			p.fail(key, "must be a hex color like ffffff")
+			return ""
+		}
+	}
+
+	value = strings.ToLower(value)
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+	return value
+}
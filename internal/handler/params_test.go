@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"image-service/internal/apierror"
+	"image-service/internal/processor"
+)
+
+func TestParseTransformOptionsDefaults(t *testing.T) {
+	imageURL, opts, err := parseTransformOptions(url.Values{"url": {"https://example.com/a.jpg"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageURL != "https://example.com/a.jpg" {
+		t.Errorf("url = %q", imageURL)
+	}
+
+	want := processor.TransformOptions{
+		Fit:        "cover",
+		Format:     "jpeg",
+		Quality:    80,
+		Contrast:   1,
+		Saturation: 1,
+		Strip:      true,
+	}
+	if opts != want {
+		t.Errorf("opts = %+v, want %+v", opts, want)
+	}
+}
+
+func TestParseTransformOptionsNormalizes(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"f=jpg", "f=JPEG"},
+		{"f=webp", "f= webp "},
+		{"rotate=-90", "rotate=270"},
+		{"rotate=360", ""},
+		{"rotate=450", "rotate=90"},
+		{"bg=%23FFF", "bg=ffffff"},
+		{"crop=1,+2,3,+4", "crop=1,2,3,4"},
+		{"sharpen=1.004", "sharpen=1"},
+		{"flip=vh", "flip=both"},
+		{"grayscale=1", "bw=true"},
+		{"strip=TRUE", ""},
+		{"auto=t", "auto=true"},
+		{"fit=contain", ""},
+		{"w=100&fit=Cover", "w=100"},
+		{"q=80", ""},
+	}
+
+	h := &Handler{}
+	for _, tt := range tests {
+		qa, _ := url.ParseQuery(tt.a)
+		qb, _ := url.ParseQuery(tt.b)
+
+		_, a, err := parseTransformOptions(qa, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.a, err)
+		}
+		_, b, err := parseTransformOptions(qb, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.b, err)
+		}
+
+		if a != b {
+			t.Errorf("%q and %q differ: %+v vs %+v", tt.a, tt.b, a, b)
+		}
+		if h.generateCacheKey("src", a) != h.generateCacheKey("src", b) {
+			t.Errorf("%q and %q have different cache keys", tt.a, tt.b)
+		}
+	}
+}
+
+func TestParseTransformOptionsRejects(t *testing.T) {
+	tests := []struct {
+		query  string
+		strict bool
+		fields []string
+	}{
+		{"w=abc", false, []string{"w"}},
+		{"w=-1", false, []string{"w"}},
+		{"q=0", false, []string{"q"}},
+		{"q=101", false, []string{"q"}},
+		{"fit=stretch", false, []string{"fit"}},
+		{"f=gif", false, []string{"f"}},
+		{"crop=1,2,3", false, []string{"crop"}},
+		{"crop=a,b,c,d", false, []string{"crop"}},
+		{"crop=-1,0,10,10", false, []string{"crop"}},
+		{"crop=0,0,0,10", false, []string{"crop"}},
+		{"blur=101", false, []string{"blur"}},
+		{"sharpen=nan", false, []string{"sharpen"}},
+		{"brightness=-101", false, []string{"brightness"}},
+		{"contrast=0", false, []string{"contrast"}},
+		{"saturation=3.5", false, []string{"saturation"}},
+		{"auto=yes", false, []string{"auto"}},
+		{"bw=maybe", false, []string{"bw"}},
+		{"grayscale=true&bw=maybe", false, []string{"bw"}},
+		{"flip=x", false, []string{"flip"}},
+		{"rotate=45", false, []string{"rotate"}},
+		{"bg=zzz", false, []string{"bg"}},
+		{"bg=ffff", false, []string{"bg"}},
+		{"w=abc&q=0&rotate=45", false, []string{"q", "rotate", "w"}},
+		{"foo=1", true, []string{"foo"}},
+		{"foo=1&w=x", true, []string{"foo", "w"}},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		_, _, err := parseTransformOptions(query, tt.strict)
+
+		var apiErr *apierror.Error
+		if !errors.As(err, &apiErr) {
+			t.Errorf("%s: err = %v, want an *apierror.Error", tt.query, err)
+			continue
+		}
+		if apiErr.Status != http.StatusBadRequest || apiErr.Code != apierror.InvalidParam {
+			t.Errorf("%s: got %d %s, want 400 INVALID_PARAM", tt.query, apiErr.Status, apiErr.Code)
+		}
+
+		fields := slices.Sorted(maps.Keys(apiErr.Fields))
+		if !slices.Equal(fields, tt.fields) {
+			t.Errorf("%s: fields = %v, want %v", tt.query, apiErr.Fields, tt.fields)
+		}
+	}
+}
+
+func TestParseTransformOptionsLeavesSizeLimitToProcessor(t *testing.T) {
+	// Past MAX_OUTPUT_DIMENSION is a 422 from the processor, not a bad parameter
+	_, opts, err := parseTransformOptions(url.Values{"w": {"100000"}, "h": {"8193"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Width != 100000 || opts.Height != 8193 {
+		t.Errorf("size = %dx%d, want 100000x8193", opts.Width, opts.Height)
+	}
+}
+
+func TestParseTransformOptionsUnknownParams(t *testing.T) {
+	query := url.Values{"url": {"u"}, "preset": {"p"}, "sig": {"s"}, "exp": {"1"}, "w": {"10"}, "foo": {"1"}}
+
+	if _, _, err := parseTransformOptions(query, false); err != nil {
+		t.Errorf("lenient mode: %v", err)
+	}
+
+	query.Del("foo")
+	if _, _, err := parseTransformOptions(query, true); err != nil {
+		t.Errorf("strict mode with known parameters: %v", err)
+	}
+}
@@ -1,216 +1,537 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"image-service/internal/apierror"
 	"image-service/internal/cache"
+	"image-service/internal/coalesce"
+	"image-service/internal/origin"
 	"image-service/internal/processor"
+	"image-service/internal/source"
+	"image-service/pkg/config"
 )
 
 type Handler struct {
-	cache        *cache.Cache
-	processor    *processor.Processor
-	maxImageSize int64
+	cache           cache.Cache
+	originals       *cache.Originals
+	failures        *cache.Failures
+	softTTL         time.Duration
+	hardTTL         time.Duration
+	processor       *processor.Processor
+	inflight        coalesce.Group[*cache.Entry]
+	refreshing      sync.Map // cache keys with a recent background refresh
+	maxImageSize    int64
+	cacheUploads    bool
+	presets         map[string]url.Values
+	presetsOnly     bool
+	strictParams    bool
+	fallback        *fallback
+	fallbacks       map[string]*fallback // by preset
+	fallbackMaxAge  int
+	distributedLock bool
+	lockTimeout     time.Duration
+	origins         *origin.Registry
+	sources         source.Sources
 }
 
-func NewHandler(c *cache.Cache, p *processor.Processor, maxSize int64) *Handler {
-	return &Handler{
-		cache:        c,
-		processor:    p,
-		maxImageSize: maxSize,
+const negativeCacheSize = 16 * 1024 * 1024
+
+func NewHandler(c cache.Cache, originals *cache.Originals, p *processor.Processor, origins *origin.Registry, sources source.Sources, cfg *config.Config) (*Handler, error) {
+	presets := make(map[string]url.Values, len(cfg.Presets))
+	for name, params := range cfg.Presets {
+		values := url.Values{}
+		for key, value := range params {
+			values.Set(key, value)
+		}
+		// A typo in a preset would otherwise fail every request using it
+		if _, _, err := parseTransformOptions(values, true); err != nil {
+			return nil, fmt.Errorf("preset %q: %w", name, err)
+		}
+		presets[name] = values
+	}
+
+	var globalFallback *fallback
+	if cfg.FallbackImage != "" {
+		var err error
+		if globalFallback, err = loadFallback(cfg.FallbackImage); err != nil {
+			return nil, err
+		}
+	}
+
+	fallbacks := make(map[string]*fallback, len(cfg.Fallbacks))
+	for name, path := range cfg.Fallbacks {
+		fb, err := loadFallback(path)
+		if err != nil {
+			return nil, fmt.Errorf("preset %q: %w", name, err)
+		}
+		fallbacks[name] = fb
+	}
+
+	// Failures are small and short-lived, a per-instance cache is enough
+	var failures *cache.Failures
+	if cfg.NegativeTTL > 0 {
+		failures = cache.NewFailures(cache.NewMemoryCache(negativeCacheSize, cfg.NegativeTTL))
 	}
+
+	return &Handler{
+		cache:           c,
+		originals:       originals,
+		failures:        failures,
+		softTTL:         time.Duration(cfg.CacheSoftTTL) * time.Second,
+		hardTTL:         time.Duration(cfg.CacheTTL) * time.Second,
+		processor:       p,
+		maxImageSize:    cfg.MaxImageSize,
+		cacheUploads:    cfg.CacheUploads,
+		presets:         presets,
+		presetsOnly:     cfg.PresetsOnly,
+		strictParams:    cfg.StrictParams,
+		fallback:        globalFallback,
+		fallbacks:       fallbacks,
+		fallbackMaxAge:  cfg.FallbackMaxAge,
+		distributedLock: cfg.CacheLock,
+		lockTimeout:     time.Duration(cfg.LockTimeout) * time.Second,
+		origins:         origins,
+		sources:         sources,
+	}, nil
 }
 
 func (h *Handler) Transform(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	query := r.URL.Query()
-	imageURL := query.Get("url")
-	width, _ := strconv.Atoi(query.Get("w"))
-	height, _ := strconv.Atoi(query.Get("h"))
-	fit := query.Get("fit")
-	if fit == "" {
-		fit = "cover"
-	}
-	format := query.Get("f")
-	if format == "" {
-		format = "jpeg"
-	}
-	quality, _ := strconv.Atoi(query.Get("q"))
-	if quality <= 0 || quality > 100 {
-		quality = 80
-	}
-	crop := query.Get("crop")
-	blur, _ := strconv.Atoi(query.Get("blur"))
-
-	// Advanced parameters
-	sharpen, _ := strconv.ParseFloat(query.Get("sharpen"), 64)
-	brightness, _ := strconv.ParseFloat(query.Get("brightness"), 64)
-	contrast, _ := strconv.ParseFloat(query.Get("contrast"), 64)
-	if contrast == 0 {
-		contrast = 1.0
-	}
-	saturation, _ := strconv.ParseFloat(query.Get("saturation"), 64)
-	if saturation == 0 {
-		saturation = 1.0
-	}
-	autoOptim := query.Get("auto") == "true" || query.Get("auto") == "1"
-	grayscale := query.Get("grayscale") == "true" || query.Get("bw") == "true"
-	flip := query.Get("flip")
-	rotate, _ := strconv.Atoi(query.Get("rotate"))
-	background := query.Get("bg")
-	strip := query.Get("strip") != "false"
-
-	cacheKey := h.generateCacheKey(
-		imageURL, width, height, fit, format, quality, crop, blur,
-		sharpen, brightness, contrast, saturation, autoOptim, grayscale, flip, rotate, background, strip,
-	)
+	imageURL, opts, err := h.transformOptions(w, r)
+	if err != nil {
+		apierror.Write(w, r, invalidParam(err))
+		return
+	}
+
+	// Headers forwarded to the origin can change the image, so they are part of the variant
+	forwarded := h.forwardedHeaders(imageURL, r.Header)
+	variant := imageURL
+	for key := range forwarded {
+		w.Header().Add("Vary", key)
+	}
+	if len(forwarded) > 0 {
+		var buf bytes.Buffer
+		forwarded.Write(&buf)
+		variant += "\n" + buf.String()
+	}
+
+	cacheKey := h.generateCacheKey(variant, opts)
+
+	// Concurrent requests for the same variant share one download and transform
+	produce := func(ctx context.Context) (*cache.Entry, error) {
+		return h.produce(ctx, imageURL, forwarded, opts, cacheKey)
+	}
 
 	// Check cache
 	if cached, err := h.cache.Get(ctx, cacheKey); err == nil {
-		contentType := h.getContentType(format)
-		// Check if cached data is SVG
-		if h.isSVG(cached) {
-			contentType = "image/svg+xml"
+		status := "HIT"
+		if h.stale(cached) {
+			// Serve the stale copy right away and refresh it for later requests
+			status = "STALE"
+			h.refresh(cacheKey, produce)
 		}
-		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("X-Cache", "HIT")
-		w.Header().Set("Cache-Control", "public, max-age=31536000")
-		w.Write(cached)
+		h.writeImage(w, r, cached, status)
 		return
 	}
 
-	// Download image
-	imageData, err := h.downloadImage(imageURL)
+	entry, err, _ := h.inflight.Do(ctx, cacheKey, produce)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to download image: %v", err), http.StatusBadGateway)
+		if !h.writeFallback(w, r, err, r.URL.Query().Get("preset"), opts) {
+			apierror.Write(w, r, err)
+		}
+		return
+	}
+
+	h.writeImage(w, r, entry, "MISS")
+}
+
+// refresh produces cacheKey again in the background. Further stale hits
+// don't start another refresh until the lock timeout has passed, when
+// another instance holds the lock it is the one refreshing.
+func (h *Handler) refresh(cacheKey string, produce func(context.Context) (*cache.Entry, error)) {
+	if _, running := h.refreshing.LoadOrStore(cacheKey, struct{}{}); running {
 		return
 	}
 
-	if int64(len(imageData)) > h.maxImageSize {
-		http.Error(w, "Image too large", http.StatusRequestEntityTooLarge)
+	go func() {
+		h.inflight.Do(context.Background(), cacheKey, produce)
+		time.AfterFunc(h.lockTimeout, func() {
+			h.refreshing.Delete(cacheKey)
+		})
+	}()
+}
+
+// produce downloads and transforms imageURL and caches the result. With
+// distributed locking enabled only one instance produces a given variant,
+// the others wait for it to show up in the cache. ctx is cancelled once no
+// client is waiting for the result anymore. Downloads are bounded by their
+// source's timeout, not by the lock's.
+func (h *Handler) produce(ctx context.Context, imageURL string, forwarded http.Header, opts processor.TransformOptions, cacheKey string) (*cache.Entry, error) {
+	var unlock func()
+	if locker, ok := h.cache.(cache.Locker); ok && h.distributedLock {
+		var entry *cache.Entry
+		entry, unlock = h.lockVariant(ctx, locker, cacheKey)
+		if entry != nil {
+			return entry, nil
+		}
+		if unlock != nil {
+			defer unlock()
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Download image
+	image, err := h.original(ctx, imageURL, forwarded)
+	if err != nil {
+		return nil, downloadError(err)
+	}
+
+	entry, err := h.render(ctx, image.Data, opts, imageURL, image.LastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	if unlock != nil {
+		// Waiting instances poll the cache, so it has to be there before unlocking
+		h.cache.Set(ctx, cacheKey, entry)
+	} else {
+		h.store(cacheKey, entry)
+	}
+
+	return entry, nil
+}
+
+// lockVariant takes the distributed lock for cacheKey. While another instance
+// holds it, it polls the cache for that instance's result and returns it.
+// When locking fails or waiting for it times out both results are nil and
+// the caller proceeds on its own.
+func (h *Handler) lockVariant(ctx context.Context, locker cache.Locker, cacheKey string) (*cache.Entry, func()) {
+	ctx, cancel := context.WithTimeout(ctx, h.lockTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		unlock, acquired, err := locker.TryLock(ctx, cacheKey, h.lockTimeout)
+		if err != nil {
+			return nil, nil
+		}
+		if acquired {
+			return nil, unlock
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-ticker.C:
+		}
+
+		if entry, err := h.cache.Get(ctx, cacheKey); err == nil {
+			return entry, nil
+		}
+	}
+}
+
+// transformOptions validates the request's transform parameters, expands a
+// named preset and resolves f=auto against the Accept header.
+func (h *Handler) transformOptions(w http.ResponseWriter, r *http.Request) (string, processor.TransformOptions, error) {
+	query, err := h.applyPreset(r.URL.Query())
+	if err != nil {
+		return "", processor.TransformOptions{}, err
+	}
+
+	imageURL, opts, err := parseTransformOptions(query, h.strictParams)
+	if err != nil {
+		return "", processor.TransformOptions{}, err
+	}
+
+	if opts.Format == "auto" {
+		// The negotiated format ends up in the cache key, so each variant is cached separately
+		opts.Format = negotiateFormat(r.Header.Get("Accept"))
+		w.Header().Add("Vary", "Accept")
+	}
+
+	return imageURL, opts, nil
+}
+
+// Parameters that identify the request rather than describe a transformation
+var nonTransformParams = map[string]bool{
+	"url":    true,
+	"preset": true,
+	"sig":    true,
+	"exp":    true,
+}
+
+// applyPreset merges the named preset under the explicitly given parameters.
+// In presets-only mode ad-hoc parameters are rejected instead.
+func (h *Handler) applyPreset(query url.Values) (url.Values, error) {
+	name := query.Get("preset")
+
+	if h.presetsOnly {
+		if name == "" {
+			return nil, errors.New("a preset is required")
+		}
+		for key := range query {
+			if !nonTransformParams[key] {
+				return nil, fmt.Errorf("parameter %q is not allowed, use a preset", key)
+			}
+		}
+	}
+
+	if name == "" {
+		return query, nil
+	}
+
+	preset, ok := h.presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q", name)
+	}
+
+	merged := url.Values{}
+	for key, values := range preset {
+		merged[key] = values
+	}
+	for key, values := range query {
+		merged[key] = values
+	}
+	return merged, nil
+}
+
+// process transforms imageData, stores the result under cacheKey (unless it
+// is empty) indexed by source, and writes the response.
+func (h *Handler) process(w http.ResponseWriter, r *http.Request, imageData []byte, opts processor.TransformOptions, source, cacheKey string, lastModified time.Time) {
+	entry, err := h.render(r.Context(), imageData, opts, source, lastModified)
+	if err != nil {
+		apierror.Write(w, r, err)
 		return
 	}
 
+	h.store(cacheKey, entry)
+	h.writeImage(w, r, entry, "MISS")
+}
+
+// render transforms imageData into a cache entry for source. Size limits
+// are enforced while reading the image, they differ per origin.
+func (h *Handler) render(ctx context.Context, imageData []byte, opts processor.TransformOptions, source string, lastModified time.Time) (*cache.Entry, error) {
+	entry := &cache.Entry{
+		Meta: cache.Metadata{
+			Source:       source,
+			LastModified: lastModified,
+			CreatedAt:    time.Now(),
+		},
+	}
+
 	// Check if input is SVG
 	if h.isSVG(imageData) {
 		// SVG detected - return as-is (no transformations)
-		go func() {
-			bgCtx := context.Background()
-			h.cache.Set(bgCtx, cacheKey, imageData)
-		}()
-
-		w.Header().Set("Content-Type", "image/svg+xml")
-		w.Header().Set("X-Cache", "MISS")
-		w.Header().Set("Cache-Control", "public, max-age=31536000")
-		w.Write(imageData)
-		return
+		entry.Meta.ContentType = "image/svg+xml"
+		entry.Data = imageData
+	} else {
+		// Process non-SVG images
+		result, err := h.processor.Transform(ctx, imageData, opts)
+		if err != nil {
+			return nil, processError(err)
+		}
+
+		entry.Meta.ContentType = h.getContentType(result.Format)
+		entry.Meta.Width = result.Width
+		entry.Meta.Height = result.Height
+		entry.Data = result.Data
 	}
+	entry.Meta.ETag = generateETag(entry.Data)
 
-	// Process non-SVG images
-	opts := processor.TransformOptions{
-		Width:      width,
-		Height:     height,
-		Fit:        fit,
-		Format:     format,
-		Quality:    quality,
-		Crop:       crop,
-		Blur:       blur,
-		Sharpen:    sharpen,
-		Brightness: brightness,
-		Contrast:   contrast,
-		Saturation: saturation,
-		AutoOptim:  autoOptim,
-		Grayscale:  grayscale,
-		Flip:       flip,
-		Rotate:     rotate,
-		Background: background,
-		Strip:      strip,
-	}
-
-	transformed, err := h.processor.Transform(imageData, opts)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to transform image: %v", err), http.StatusInternalServerError)
+	return entry, nil
+}
+
+func (h *Handler) store(cacheKey string, entry *cache.Entry) {
+	if cacheKey == "" {
 		return
 	}
 
 	go func() {
 		bgCtx := context.Background()
-		h.cache.Set(bgCtx, cacheKey, transformed)
+		h.cache.Set(bgCtx, cacheKey, entry)
 	}()
+}
+
+// stale reports whether entry is past the soft TTL and should be refreshed.
+func (h *Handler) stale(entry *cache.Entry) bool {
+	return h.softTTL > 0 && time.Since(entry.Meta.CreatedAt) >= h.softTTL
+}
+
+// cacheControl lets downstream caches follow the same soft and hard TTLs:
+// fresh until the soft TTL, then served stale while revalidating or when
+// refreshing fails, until the entry expires here too.
+func (h *Handler) cacheControl(entry *cache.Entry) string {
+	if h.softTTL <= 0 {
+		return "public, max-age=31536000"
+	}
+
+	fresh := max(h.softTTL-time.Since(entry.Meta.CreatedAt), 0)
+	staleFor := max(h.hardTTL-h.softTTL, 0)
+	return fmt.Sprintf("public, max-age=%d, stale-while-revalidate=%d, stale-if-error=%d",
+		int(fresh.Seconds()), int(staleFor.Seconds()), int(staleFor.Seconds()))
+}
+
+// writeImage sends the entry with its ETag and, when known, the origin's
+// Last-Modified. http.ServeContent answers If-None-Match and
+// If-Modified-Since with 304 Not Modified.
+func (h *Handler) writeImage(w http.ResponseWriter, r *http.Request, entry *cache.Entry, cacheStatus string) {
+	w.Header().Set("Content-Type", entry.Meta.ContentType)
+	w.Header().Set("X-Cache", cacheStatus)
+	w.Header().Set("Cache-Control", h.cacheControl(entry))
+	if entry.Meta.ETag != "" {
+		w.Header().Set("ETag", entry.Meta.ETag)
+	}
+	if entry.Meta.ContentType == "image/svg+xml" {
+		// SVGs pass through untouched, keep any script in them from running
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
 
-	w.Header().Set("Content-Type", h.getContentType(format))
-	w.Header().Set("X-Cache", "MISS")
-	w.Header().Set("Cache-Control", "public, max-age=31536000")
-	w.Write(transformed)
+	http.ServeContent(w, r, "", entry.Meta.LastModified, bytes.NewReader(entry.Data))
 }
 
-func (h *Handler) downloadImage(imageURL string) ([]byte, error) {
+func generateETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// forwardedHeaders returns the client headers the source's origin profile
+// asks to be passed on, nil for most sources.
+func (h *Handler) forwardedHeaders(imageURL string, clientHeader http.Header) http.Header {
 	parsedURL, err := url.Parse(imageURL)
 	if err != nil {
-		return nil, err
+		return nil
+	}
+	return h.origins.Match(parsedURL).Forwarded(clientHeader)
+}
+
+// original returns the source image, from the originals cache when it is
+// enabled. Stale copies are revalidated with the source rather than
+// downloaded again.
+func (h *Handler) original(ctx context.Context, imageURL string, forwarded http.Header) (*source.Image, error) {
+	// Forwarded client headers can make the original differ per client
+	if h.originals == nil || len(forwarded) > 0 {
+		return h.downloadImage(ctx, imageURL, forwarded, nil)
+	}
+
+	cached, fresh, err := h.originals.Get(ctx, imageURL)
+	if err != nil {
+		cached = nil
+	} else if fresh {
+		return &source.Image{Data: cached.Data, ETag: cached.Meta.ETag, LastModified: cached.Meta.LastModified}, nil
 	}
-	baseURL := parsedURL.Scheme + "://" + parsedURL.Host
 
-	req, err := http.NewRequest("GET", imageURL, nil)
+	image, err := h.downloadImage(ctx, imageURL, forwarded, cached)
 	if err != nil {
+		// A stale copy beats no image while the origin is down
+		var apiErr *apierror.Error
+		if cached != nil && errors.As(err, &apiErr) && (apiErr.Code == apierror.OriginError || apiErr.Code == apierror.OriginTimeout) {
+			return &source.Image{Data: cached.Data, ETag: cached.Meta.ETag, LastModified: cached.Meta.LastModified}, nil
+		}
 		return nil, err
 	}
+	if image.NotModified {
+		image.Data = cached.Data
+	}
 
-	// Comprehensive browser headers
-	req.Header.Set("X-Image-Service", "railway-transform-v1")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
-	req.Header.Set("Accept", "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-	req.Header.Set("Referer", baseURL+"/")
-	req.Header.Set("Origin", baseURL)
-	req.Header.Set("Sec-Ch-Ua", `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`)
-	req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
-	req.Header.Set("Sec-Ch-Ua-Platform", `"Windows"`)
-	req.Header.Set("Sec-Fetch-Dest", "image")
-	req.Header.Set("Sec-Fetch-Mode", "no-cors")
-	req.Header.Set("Sec-Fetch-Site", "cross-site")
-	req.Header.Set("DNT", "1")
-	req.Header.Set("Connection", "keep-alive")
-
-	client := &http.Client{
-		Timeout: 15 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-			TLSHandshakeTimeout: 10 * time.Second,
-		},
+	// Stored again after a revalidation too, to restart its freshness
+	go func() {
+		h.originals.Set(context.Background(), imageURL, &cache.Entry{
+			Meta: cache.Metadata{
+				Source:       imageURL,
+				ETag:         image.ETag,
+				LastModified: image.LastModified,
+				CreatedAt:    time.Now(),
+			},
+			Data: image.Data,
+		})
+	}()
+
+	return image, nil
+}
+
+// downloadImage fetches the original image from whichever source its URL
+// scheme points to. With stale set, only changes to it are downloaded.
+//
+// Sources that recently failed get the same error again without being
+// fetched, unless client headers are forwarded and the failure might have
+// been specific to that client. Failed revalidations aren't remembered,
+// the stale copy is served instead.
+func (h *Handler) downloadImage(ctx context.Context, imageURL string, forwarded http.Header, stale *cache.Entry) (*source.Image, error) {
+	remember := h.failures != nil && len(forwarded) == 0
+	if remember {
+		if failure, err := h.failures.Get(ctx, imageURL); err == nil {
+			return nil, apierror.New(failure.Status, apierror.Code(failure.Code), failure.Message)
+		}
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
+	image, err := h.fetchImage(ctx, imageURL, forwarded, stale)
+	if err == nil {
+		return image, nil
+	}
+
+	// The caller giving up says nothing about the origin
+	if ctx.Err() != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	err = downloadError(err)
+	var apiErr *apierror.Error
+	if remember && stale == nil && errors.As(err, &apiErr) && negativeCacheable[apiErr.Code] {
+		go h.failures.Set(context.Background(), imageURL, &cache.Failure{
+			Status:  apiErr.Status,
+			Code:    string(apiErr.Code),
+			Message: apiErr.Message,
+		})
 	}
+	return nil, err
+}
 
-	limitReader := io.LimitReader(resp.Body, h.maxImageSize+1)
-	data, err := io.ReadAll(limitReader)
+// Failures worth remembering: missing images and unreachable origins, not
+// limits that depend on our own configuration
+var negativeCacheable = map[apierror.Code]bool{
+	apierror.OriginNotFound: true,
+	apierror.OriginError:    true,
+	apierror.OriginTimeout:  true,
+}
+
+func (h *Handler) fetchImage(ctx context.Context, imageURL string, forwarded http.Header, stale *cache.Entry) (*source.Image, error) {
+	parsedURL, err := url.Parse(imageURL)
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	req := &source.Request{
+		URL:     parsedURL,
+		Header:  forwarded,
+		MaxSize: h.maxImageSize,
+	}
+	if stale != nil {
+		req.ETag = stale.Meta.ETag
+		req.LastModified = stale.Meta.LastModified
+	}
+
+	return h.sources.Fetch(ctx, req)
 }
 
 func (h *Handler) isSVG(data []byte) bool {
@@ -239,15 +560,40 @@ func (h *Handler) isSVG(data []byte) bool {
 		strings.Contains(prefix, "xmlns='http://www.w3.org/2000/svg'")
 }
 
-func (h *Handler) generateCacheKey(imageURL string, w, ht int, fit, format string, quality int, crop string, blur int,
-	sharpen, brightness, contrast, saturation float64, autoOptim, grayscale bool, flip string, rotate int, bg string, strip bool) string {
+func (h *Handler) generateCacheKey(imageURL string, opts processor.TransformOptions) string {
 	data := fmt.Sprintf("%s:%d:%d:%s:%s:%d:%s:%d:%.2f:%.2f:%.2f:%.2f:%t:%t:%s:%d:%s:%t",
-		imageURL, w, ht, fit, format, quality, crop, blur,
-		sharpen, brightness, contrast, saturation, autoOptim, grayscale, flip, rotate, bg, strip)
+		imageURL, opts.Width, opts.Height, opts.Fit, opts.Format, opts.Quality, opts.Crop, opts.Blur,
+		opts.Sharpen, opts.Brightness, opts.Contrast, opts.Saturation, opts.AutoOptim, opts.Grayscale,
+		opts.Flip, opts.Rotate, opts.Background, opts.Strip)
 	hashBytes := md5.Sum([]byte(data))
 	return hex.EncodeToString(hashBytes[:])
 }
 
+// negotiateFormat picks the best output format the client advertises in Accept.
+// When neither AVIF nor WebP is accepted it returns "auto" and the processor
+// chooses PNG or JPEG depending on whether the image has an alpha channel.
+func negotiateFormat(accept string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(mediaType))] = true
+	}
+
+	switch {
+	case accepted["image/avif"]:
+		return "avif"
+	case accepted["image/webp"]:
+		return "webp"
+	default:
+		return "auto"
+	}
+}
+
 func (h *Handler) getContentType(format string) string {
 	switch format {
 	case "webp":
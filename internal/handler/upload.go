@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"image-service/internal/apierror"
+)
+
+// Upload transforms an image sent in the request body, either raw or as the
+// first file of a multipart form, using the same query parameters as Transform.
+func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Abort the read as soon as the body grows past the limit
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxImageSize)
+
+	imageData, err := readUpload(r)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			apierror.Write(w, r, apierror.New(http.StatusRequestEntityTooLarge, apierror.TooLarge, "Image too large"))
+			return
+		}
+		apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Failed to read upload: "+err.Error()))
+		return
+	}
+
+	if len(imageData) == 0 {
+		apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Missing image data"))
+		return
+	}
+
+	// Echoing an uploaded SVG would serve attacker-supplied script from our origin
+	if h.isSVG(imageData) {
+		apierror.Write(w, r, apierror.New(http.StatusUnsupportedMediaType, apierror.UnsupportedFormat, "SVG uploads are not supported"))
+		return
+	}
+
+	_, opts, err := h.transformOptions(w, r)
+	if err != nil {
+		apierror.Write(w, r, invalidParam(err))
+		return
+	}
+
+	sum := sha256.Sum256(imageData)
+	source := "sha256:" + hex.EncodeToString(sum[:])
+
+	cacheKey := ""
+	if h.cacheUploads {
+		cacheKey = h.generateCacheKey(source, opts)
+
+		if cached, err := h.cache.Get(ctx, cacheKey); err == nil {
+			h.writeImage(w, r, cached, "HIT")
+			return
+		}
+	}
+
+	h.process(w, r, imageData, opts, source, cacheKey, time.Time{})
+}
+
+func readUpload(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return io.ReadAll(r.Body)
+	}
+
+	// Stream the parts instead of ParseMultipartForm, which spools to disk
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("no file in multipart body")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Only file parts, plain form fields are what a cross-site form can forge
+		if part.FileName() != "" {
+			defer part.Close()
+			return io.ReadAll(part)
+		}
+		part.Close()
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadRejects(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`
+
+	multipartBody := func(field, fileName, content string) (string, *bytes.Buffer) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		var part io.Writer
+		if fileName == "" {
+			part, _ = mw.CreateFormField(field)
+		} else {
+			part, _ = mw.CreateFormFile(field, fileName)
+		}
+		part.Write([]byte(content))
+		mw.Close()
+		return mw.FormDataContentType(), &body
+	}
+
+	svgType, svgFile := multipartBody("file", "a.svg", svg)
+	// What a cross-site form without a file input sends
+	fieldType, fieldOnly := multipartBody("file", "", "\xff\xd8\xff")
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        *bytes.Buffer
+		status      int
+		code        string
+	}{
+		{"invalid params", "w=abc&q=0", "image/jpeg", bytes.NewBufferString("\xff\xd8\xff"), http.StatusBadRequest, "INVALID_PARAM"},
+		{"too large", "", "image/jpeg", bytes.NewBufferString(strings.Repeat("x", 2048)), http.StatusRequestEntityTooLarge, "TOO_LARGE"},
+		{"empty", "", "image/jpeg", &bytes.Buffer{}, http.StatusBadRequest, "INVALID_PARAM"},
+		{"raw svg", "", "image/svg+xml", bytes.NewBufferString(svg), http.StatusUnsupportedMediaType, "UNSUPPORTED_FORMAT"},
+		{"multipart svg", "", svgType, svgFile, http.StatusUnsupportedMediaType, "UNSUPPORTED_FORMAT"},
+		{"form field, not a file", "", fieldType, fieldOnly, http.StatusBadRequest, "INVALID_PARAM"},
+	}
+
+	h := &Handler{maxImageSize: 1024}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/transform?"+tt.query, tt.body)
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Accept", "application/json")
+			rec := httptest.NewRecorder()
+
+			h.Upload(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			var resp struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("body %q isn't JSON: %v", rec.Body.String(), err)
+			}
+			if resp.Error.Code != tt.code {
+				t.Errorf("code = %q, want %q", resp.Error.Code, tt.code)
+			}
+		})
+	}
+}
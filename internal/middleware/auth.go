@@ -3,53 +3,31 @@ package middleware
 import (
 	"net/http"
 	"net/url"
-	"strings"
+
+	"image-service/internal/allowlist"
+	"image-service/internal/apierror"
 )
 
-func Auth(allowedDomains []string) func(http.Handler) http.Handler {
+func Auth(allowed *allowlist.List) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			imageURL := r.URL.Query().Get("url")
 			if imageURL == "" {
-				http.Error(w, "Missing URL parameter", http.StatusBadRequest)
+				apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Missing URL parameter"))
 				return
 			}
 
-			// Decode URL-encoded characters (like %20 for space, %201 etc)
-			decodedURL, err := url.QueryUnescape(imageURL)
-			if err != nil {
-				// If decode fails, use original URL
-				decodedURL = imageURL
-			}
-
-			// Parse the URL
-			parsedURL, err := url.Parse(decodedURL)
+			// Query().Get has already decoded the value. Parse it exactly as the
+			// sources will, unescaping again would let the checked host differ
+			// from the one that is fetched.
+			parsedURL, err := url.Parse(imageURL)
 			if err != nil {
-				// Try parsing the original URL if decoded fails
-				parsedURL, err = url.Parse(imageURL)
-				if err != nil {
-					http.Error(w, "Invalid URL", http.StatusBadRequest)
-					return
-				}
-			}
-
-			// Check if wildcard is enabled
-			allowed := false
-			for _, domain := range allowedDomains {
-				// Allow all domains if * is present
-				if domain == "*" {
-					allowed = true
-					break
-				}
-				// Check specific domain (supports subdomains)
-				if domain != "" && (strings.HasSuffix(parsedURL.Host, domain) || parsedURL.Host == domain) {
-					allowed = true
-					break
-				}
+				apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Invalid URL"))
+				return
 			}
 
-			if !allowed {
-				http.Error(w, "Domain not allowed", http.StatusForbidden)
+			if !allowed.Allowed(parsedURL) {
+				apierror.Write(w, r, apierror.New(http.StatusForbidden, apierror.DomainNotAllowed, "Domain not allowed"))
 				return
 			}
 
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"image-service/internal/allowlist"
+)
+
+func TestAuth(t *testing.T) {
+	allowed, err := allowlist.New([]string{"allowed.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		rawQuery string
+		want     int
+	}{
+		{"allowed", "url=" + url.QueryEscape("https://allowed.com/a.jpg"), http.StatusOK},
+		{"not allowed", "url=" + url.QueryEscape("https://evil-host/a.jpg"), http.StatusForbidden},
+		{"missing", "", http.StatusBadRequest},
+		{"invalid", "url=" + url.QueryEscape("http://[::1"), http.StatusBadRequest},
+		// Unescaping twice would check allowed.com and fetch evil-host
+		{"encoded userinfo", "url=" + url.QueryEscape("http://allowed.com%2F@evil-host/"), http.StatusForbidden},
+		{"double encoded userinfo", "url=http%3A%2F%2Fallowed.com%252F%40evil-host%2F", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			req := httptest.NewRequest("GET", "/transform?"+tt.rawQuery, nil)
+			rec := httptest.NewRecorder()
+
+			Auth(allowed)(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
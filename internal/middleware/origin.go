@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"image-service/internal/apierror"
+	"image-service/internal/origin"
+)
+
+// Origins resolves origin aliases (?src=cms&path=/a.jpg) into the url
+// parameter, after the signature over the original query has been checked.
+func Origins(origins *origin.Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			name := query.Get("src")
+			if name == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if query.Get("url") != "" {
+				apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Use either url or src, not both"))
+				return
+			}
+
+			imageURL, err := origins.Resolve(name, query.Get("path"))
+			if err != nil {
+				apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, err.Error()))
+				return
+			}
+
+			query.Del("src")
+			query.Del("path")
+			query.Set("url", imageURL)
+
+			r2 := r.Clone(r.Context())
+			r2.URL.RawQuery = query.Encode()
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
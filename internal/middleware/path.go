@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"image-service/internal/apierror"
+)
+
+// PathParams rewrites path-style URLs into the equivalent query so that the
+// rest of the chain (signature, domain checks, Transform) treats both APIs
+// the same way:
+//
+//	/t/w:400,h:300,fit:cover,f:webp/<base64url source>
+//	/t/w:400,h:300,fit:cover,f:webp/plain/<escaped source>
+//
+// Use "-" as the options segment when no options are needed. Option values
+// that contain commas in the query API (crop) use ':' instead, e.g.
+// crop:10:10:200:200. signature.Signer.SignPath mints signed URLs in this form.
+func PathParams(prefix string) func(http.Handler) http.Handler {
+	return rewritePath(prefix, parsePathOptions)
+}
+
+// PresetPathParams is PathParams for named presets, where the first segment
+// is the preset name optionally followed by options (usually just the
+// signature):
+//
+//	/p/avatar/<base64url source>
+//	/p/avatar,sig:<signature>/plain/<escaped source>
+func PresetPathParams(prefix string) func(http.Handler) http.Handler {
+	return rewritePath(prefix, func(segment string) (url.Values, error) {
+		name, options, _ := strings.Cut(segment, ",")
+		if name == "" {
+			return nil, errInvalidOption
+		}
+
+		params, err := parsePathOptions(options)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("preset", name)
+		return params, nil
+	})
+}
+
+func rewritePath(prefix string, parse func(segment string) (url.Values, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rest := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+
+			options, source, found := strings.Cut(rest, "/")
+			if !found || source == "" {
+				apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Missing source"))
+				return
+			}
+
+			params, err := parse(options)
+			if err != nil {
+				apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Invalid options"))
+				return
+			}
+
+			imageURL, err := decodePathSource(source)
+			if err != nil {
+				apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.InvalidParam, "Invalid source"))
+				return
+			}
+
+			query := r.URL.Query()
+			for key, values := range params {
+				query[key] = values
+			}
+			query.Set("url", imageURL)
+
+			r2 := r.Clone(r.Context())
+			r2.URL.RawQuery = query.Encode()
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
+
+var errInvalidOption = errors.New("invalid path option")
+
+func parsePathOptions(segment string) (url.Values, error) {
+	params := url.Values{}
+	if segment == "-" || segment == "" {
+		return params, nil
+	}
+
+	segment, err := url.PathUnescape(segment)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range strings.Split(segment, ",") {
+		key, value, found := strings.Cut(option, ":")
+		if !found || key == "" {
+			return nil, errInvalidOption
+		}
+		params.Set(key, strings.ReplaceAll(value, ":", ","))
+	}
+
+	return params, nil
+}
+
+func decodePathSource(source string) (string, error) {
+	if plain, found := strings.CutPrefix(source, "plain/"); found {
+		return url.PathUnescape(plain)
+	}
+
+	// Long base64 sources may be split into several segments
+	encoded := strings.TrimRight(strings.ReplaceAll(source, "/", ""), "=")
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"image-service/pkg/signature"
+)
+
+func TestDecodePathSource(t *testing.T) {
+	src := "https://example.com/images/a b.jpg?v=1"
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(src))
+
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{"base64url", encoded, src, false},
+		{"base64url padded", base64.URLEncoding.EncodeToString([]byte(src)), src, false},
+		{"base64url split", encoded[:10] + "/" + encoded[10:], src, false},
+		{"plain", "plain/https://example.com/a.jpg", "https://example.com/a.jpg", false},
+		{"plain escaped", "plain/" + url.PathEscape(src), src, false},
+		{"plain bad escape", "plain/https://example.com/%zz", "", true},
+		{"not base64", "https://example.com/a.jpg", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodePathSource(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePathOptions(t *testing.T) {
+	tests := []struct {
+		segment string
+		want    url.Values
+		wantErr bool
+	}{
+		{"-", url.Values{}, false},
+		{"", url.Values{}, false},
+		{"w:400,h:300,fit:cover", url.Values{"w": {"400"}, "h": {"300"}, "fit": {"cover"}}, false},
+		{"crop:10:10:200:200", url.Values{"crop": {"10,10,200,200"}}, false},
+		{"bg:%23fff", url.Values{"bg": {"#fff"}}, false},
+		{"w:", url.Values{"w": {""}}, false},
+		{"w400", nil, true},
+		{":400", nil, true},
+		{"w:400,,h:300", nil, true},
+		{"w:%zz", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePathOptions(tt.segment)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, wantErr %v", tt.segment, err, tt.wantErr)
+			continue
+		}
+		if got.Encode() != tt.want.Encode() {
+			t.Errorf("%q: got %v, want %v", tt.segment, got, tt.want)
+		}
+	}
+}
+
+func TestPathParams(t *testing.T) {
+	src := "https://example.com/a.jpg"
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(src))
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		want   url.Values
+	}{
+		{"options", "/t/w:400,f:webp/" + encoded, http.StatusOK, url.Values{"w": {"400"}, "f": {"webp"}, "url": {src}}},
+		{"no options", "/t/-/plain/" + url.PathEscape(src), http.StatusOK, url.Values{"url": {src}}},
+		{"path wins over query", "/t/w:400/" + encoded + "?w=10&q=50&url=https://evil-host/", http.StatusOK, url.Values{"w": {"400"}, "q": {"50"}, "url": {src}}},
+		{"missing source", "/t/w:400", http.StatusBadRequest, nil},
+		{"invalid options", "/t/w400/" + encoded, http.StatusBadRequest, nil},
+		{"invalid source", "/t/w:400/!!!", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got url.Values
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Query()
+			})
+			req := httptest.NewRequest("GET", tt.target, nil)
+			rec := httptest.NewRecorder()
+
+			PathParams("/t/")(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.want != nil && got.Encode() != tt.want.Encode() {
+				t.Errorf("query = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresetPathParams(t *testing.T) {
+	var got url.Values
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+	})
+	req := httptest.NewRequest("GET", "/p/avatar,w:64/plain/https://example.com/a.jpg", nil)
+	rec := httptest.NewRecorder()
+
+	PresetPathParams("/p/")(next).ServeHTTP(rec, req)
+
+	want := url.Values{"preset": {"avatar"}, "w": {"64"}, "url": {"https://example.com/a.jpg"}}
+	if rec.Code != http.StatusOK || got.Encode() != want.Encode() {
+		t.Errorf("got %d %v, want 200 %v", rec.Code, got, want)
+	}
+
+	req = httptest.NewRequest("GET", "/p/,w:64/plain/https://example.com/a.jpg", nil)
+	rec = httptest.NewRecorder()
+	PresetPathParams("/p/")(next).ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("missing preset name: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestSignedPathRoundTrip(t *testing.T) {
+	signer := signature.NewSigner("k1")
+	params := url.Values{"url": {"https://example.com/a b.jpg?v=1"}, "w": {"400"}, "crop": {"10,10,200,200"}, "bg": {"#fff"}}
+	preset := url.Values{"url": {"https://example.com/a.jpg"}, "preset": {"avatar"}}
+
+	banner := signer.SignPath("/p/", with(preset, "preset", "banner"), time.Time{})
+
+	tests := []struct {
+		name   string
+		path   func(http.Handler) http.Handler
+		target string
+		want   int
+	}{
+		{"transform", PathParams("/t/"), signer.SignPath("/t/", params, time.Time{}), http.StatusOK},
+		{"transform with expiry", PathParams("/t/"), signer.SignPath("/t/", params, time.Now().Add(time.Hour)), http.StatusOK},
+		{"transform expired", PathParams("/t/"), signer.SignPath("/t/", params, time.Now().Add(-time.Hour)), http.StatusForbidden},
+		{"transform wrong key", PathParams("/t/"), signature.NewSigner("k2").SignPath("/t/", params, time.Time{}), http.StatusForbidden},
+		// The path option replaces the query one, so the signed value still applies
+		{"query can't override path", PathParams("/t/"), signer.SignPath("/t/", params, time.Time{}) + "?w=4000", http.StatusOK},
+		{"added query param", PathParams("/t/"), signer.SignPath("/t/", params, time.Time{}) + "?blur=5", http.StatusForbidden},
+		{"preset", PresetPathParams("/p/"), signer.SignPath("/p/", preset, time.Time{}), http.StatusOK},
+		{"preset swapped", PresetPathParams("/p/"), strings.Replace(banner, "/p/banner,", "/p/avatar,", 1), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got url.Values
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Query()
+			})
+			req := httptest.NewRequest("GET", tt.target, nil)
+			rec := httptest.NewRecorder()
+
+			tt.path(Signature([]string{"k1"})(next)).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("%s: status = %d, want %d", tt.target, rec.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			// What reaches Transform is exactly what was signed
+			want := params
+			if got.Has("preset") {
+				want = preset
+			}
+			got.Del("sig")
+			got.Del("exp")
+			if got.Encode() != want.Encode() {
+				t.Errorf("query = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
     "sync"
     "time"
 
+    "image-service/internal/apierror"
+
     "golang.org/x/time/rate"
 )
 
@@ -65,7 +67,12 @@ func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
         limiter := rl.getVisitor(ip)
 
         if !limiter.Allow() {
-            http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+            apierror.Write(w, r, &apierror.Error{
+                Status:     http.StatusTooManyRequests,
+                Code:       apierror.RateLimited,
+                Message:    "Rate limit exceeded",
+                RetryAfter: 60/max(rl.rate, 1) + 1, // next token, rounded up
+            })
             return
         }
 
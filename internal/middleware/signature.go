@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"image-service/internal/apierror"
+	"image-service/pkg/signature"
+)
+
+// Signature rejects requests whose query isn't signed with one of keys.
+// With no keys configured signing is disabled and every request passes.
+func Signature(keys []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(keys) == 0 {
+			return next
+		}
+
+		verifier := signature.NewVerifier(keys)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch err := verifier.Verify(r.URL.Query(), time.Now()); err {
+			case nil:
+				next.ServeHTTP(w, r)
+			case signature.ErrMissingSignature:
+				apierror.Write(w, r, apierror.New(http.StatusForbidden, apierror.InvalidSignature, "Missing signature"))
+			case signature.ErrExpired:
+				apierror.Write(w, r, apierror.New(http.StatusForbidden, apierror.InvalidSignature, "Signature expired"))
+			default:
+				apierror.Write(w, r, apierror.New(http.StatusForbidden, apierror.InvalidSignature, "Invalid signature"))
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"image-service/pkg/signature"
+)
+
+func TestSignature(t *testing.T) {
+	params := url.Values{"url": {"https://example.com/a.jpg"}, "w": {"400"}}
+	signed := func(key string, expires time.Time) url.Values {
+		return signature.NewSigner(key).SignQuery(params, expires)
+	}
+
+	tests := []struct {
+		name  string
+		keys  []string
+		query url.Values
+		want  int
+	}{
+		{"signing disabled", nil, params, http.StatusOK},
+		{"valid", []string{"k1"}, signed("k1", time.Time{}), http.StatusOK},
+		{"rotated key", []string{"k2", "k1"}, signed("k1", time.Time{}), http.StatusOK},
+		{"missing", []string{"k1"}, params, http.StatusForbidden},
+		{"wrong key", []string{"k2"}, signed("k1", time.Time{}), http.StatusForbidden},
+		{"not expired", []string{"k1"}, signed("k1", time.Now().Add(time.Hour)), http.StatusOK},
+		{"expired", []string{"k1"}, signed("k1", time.Now().Add(-time.Hour)), http.StatusForbidden},
+		{"tampered param", []string{"k1"}, with(signed("k1", time.Time{}), "w", "4000"), http.StatusForbidden},
+		{"added param", []string{"k1"}, with(signed("k1", time.Time{}), "blur", "5"), http.StatusForbidden},
+		{"extended expiry", []string{"k1"}, with(signed("k1", time.Now().Add(-time.Hour)), "exp", "99999999999"), http.StatusForbidden},
+		{"malformed", []string{"k1"}, with(params, "sig", "!!!"), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			req := httptest.NewRequest("GET", "/transform?"+tt.query.Encode(), nil)
+			rec := httptest.NewRecorder()
+
+			Signature(tt.keys)(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusForbidden && rec.Header().Get("X-Error-Code") != "INVALID_SIGNATURE" {
+				t.Errorf("X-Error-Code = %q, want INVALID_SIGNATURE", rec.Header().Get("X-Error-Code"))
+			}
+		})
+	}
+}
+
+// with returns a copy of query with key set to value.
+func with(query url.Values, key, value string) url.Values {
+	out := url.Values{}
+	for k, v := range query {
+		out[k] = append([]string(nil), v...)
+	}
+	out.Set(key, value)
+	return out
+}
@@ -0,0 +1,159 @@
+// Package origin holds per-origin fetch settings. A profile applies to every
+// source URL under its base URL and can also be addressed by name, as in
+// ?src=cms&path=/a.jpg.
+package origin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"image-service/pkg/config"
+)
+
+var ErrUnknownOrigin = errors.New("unknown origin")
+
+type Profile struct {
+	Name           string
+	BaseURL        *url.URL
+	Headers        http.Header
+	BearerToken    string
+	BasicUser      string
+	BasicPassword  string
+	Timeout        time.Duration // zero means the service default
+	MaxSize        int64         // zero means the service default
+	ForwardHeaders []string
+}
+
+type Registry struct {
+	byName map[string]*Profile
+	// Longest base path first, so the most specific profile wins
+	ordered []*Profile
+}
+
+// defaultProfile applies to sources that match no configured origin
+var defaultProfile = &Profile{}
+
+func NewRegistry(origins map[string]config.OriginConfig) (*Registry, error) {
+	reg := &Registry{byName: make(map[string]*Profile, len(origins))}
+
+	for name, oc := range origins {
+		base, err := url.Parse(oc.BaseURL)
+		if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+			return nil, fmt.Errorf("origin %q: invalid base_url %q", name, oc.BaseURL)
+		}
+		base.Path = strings.TrimSuffix(base.Path, "/")
+		base.RawQuery = ""
+		base.Fragment = ""
+
+		p := &Profile{
+			Name:        name,
+			BaseURL:     base,
+			Headers:     http.Header{},
+			BearerToken: oc.BearerToken,
+			Timeout:     time.Duration(oc.Timeout) * time.Second,
+			MaxSize:     oc.MaxSize,
+		}
+		for key, value := range oc.Headers {
+			p.Headers.Set(key, value)
+		}
+		if oc.BasicAuth != nil {
+			p.BasicUser = oc.BasicAuth.Username
+			p.BasicPassword = oc.BasicAuth.Password
+		}
+		for _, header := range oc.ForwardHeaders {
+			p.ForwardHeaders = append(p.ForwardHeaders, http.CanonicalHeaderKey(header))
+		}
+
+		reg.byName[name] = p
+		reg.ordered = append(reg.ordered, p)
+	}
+
+	sort.Slice(reg.ordered, func(i, j int) bool {
+		return len(reg.ordered[i].BaseURL.Path) > len(reg.ordered[j].BaseURL.Path)
+	})
+
+	return reg, nil
+}
+
+// Resolve turns an origin name and a path below its base URL into a source URL.
+func (reg *Registry) Resolve(name, p string) (string, error) {
+	profile, ok := reg.byName[name]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownOrigin, name)
+	}
+
+	// Clean as a rooted path so ".." can't climb above the base
+	u := *profile.BaseURL
+	u.Path = profile.BaseURL.Path + path.Clean("/"+p)
+	return u.String(), nil
+}
+
+// Match returns the profile whose base URL contains u, or an empty default
+// profile when there is none.
+func (reg *Registry) Match(u *url.URL) *Profile {
+	for _, p := range reg.ordered {
+		if p.contains(u) {
+			return p
+		}
+	}
+	return defaultProfile
+}
+
+// BaseURLs lists every origin's base URL, in the syntax allowlist rules use.
+func (reg *Registry) BaseURLs() []string {
+	var urls []string
+	for _, p := range reg.ordered {
+		urls = append(urls, p.BaseURL.String()+"/")
+	}
+	return urls
+}
+
+func (p *Profile) contains(u *url.URL) bool {
+	base := p.BaseURL
+	if !strings.EqualFold(u.Scheme, base.Scheme) || !strings.EqualFold(u.Host, base.Host) {
+		return false
+	}
+	if base.Path == "" {
+		return true
+	}
+	cleaned := path.Clean("/" + u.Path)
+	return cleaned == base.Path || strings.HasPrefix(cleaned, base.Path+"/")
+}
+
+// Forwarded picks the client request headers this origin wants passed on.
+func (p *Profile) Forwarded(clientHeader http.Header) http.Header {
+	var forwarded http.Header
+	for _, key := range p.ForwardHeaders {
+		if values := clientHeader.Values(key); len(values) > 0 {
+			if forwarded == nil {
+				forwarded = http.Header{}
+			}
+			forwarded[key] = values
+		}
+	}
+	return forwarded
+}
+
+// Apply sets the origin's headers on req, then the forwarded client headers,
+// then its credentials, which win over anything forwarded.
+func (p *Profile) Apply(req *http.Request, forwarded http.Header) {
+	for key, values := range p.Headers {
+		req.Header[key] = values
+	}
+	for key, values := range forwarded {
+		req.Header[key] = values
+	}
+
+	switch {
+	case p.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+p.BearerToken)
+	case p.BasicUser != "":
+		req.SetBasicAuth(p.BasicUser, p.BasicPassword)
+	}
+}
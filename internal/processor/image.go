@@ -1,16 +1,27 @@
 package processor
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"runtime"
+	"time"
 
 	"github.com/davidbyttow/govips/v2/vips"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
 )
 
 type TransformOptions struct {
 	Width      int
 	Height     int
 	Fit        string // cover, contain, fill
-	Format     string // jpeg, webp, avif, png
+	Format     string // jpeg, webp, avif, png, auto (png with alpha, jpeg otherwise)
 	Quality    int
 	Crop       string // "x,y,width,height"
 	Blur       int
@@ -26,34 +37,125 @@ type TransformOptions struct {
 	Strip      bool    // Strip all metadata (default: true)
 }
 
-type Processor struct{}
+var (
+	ErrTooManyPixels     = errors.New("image has too many pixels")
+	ErrOutputTooLarge    = errors.New("requested output size too large")
+	ErrUnsupportedFormat = errors.New("unsupported or corrupt image")
+	ErrInvalidOption     = errors.New("invalid transform option")
+)
+
+type Result struct {
+	Data   []byte
+	Format string // resolved output format, never "auto"
+	Width  int
+	Height int
+}
+
+type ImageInfo struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Format      string `json:"format"`
+	ColorSpace  string `json:"color_space"`
+	HasAlpha    bool   `json:"has_alpha"`
+	Orientation int    `json:"orientation"`
+	Pages       int    `json:"pages"`
+	Size        int    `json:"size"`
+	HasExif     bool   `json:"has_exif"`
+	HasICC      bool   `json:"has_icc"`
+	HasXMP      bool   `json:"has_xmp"`
+}
+
+var interpretationNames = map[vips.Interpretation]string{
+	vips.InterpretationBW:        "b-w",
+	vips.InterpretationMultiband: "multiband",
+	vips.InterpretationHistogram: "histogram",
+	vips.InterpretationXYZ:       "xyz",
+	vips.InterpretationLAB:       "lab",
+	vips.InterpretationCMYK:      "cmyk",
+	vips.InterpretationLABQ:      "labq",
+	vips.InterpretationRGB:       "rgb",
+	vips.InterpretationRGB16:     "rgb16",
+	vips.InterpretationCMC:       "cmc",
+	vips.InterpretationLCH:       "lch",
+	vips.InterpretationLABS:      "labs",
+	vips.InterpretationSRGB:      "srgb",
+	vips.InterpretationYXY:       "yxy",
+	vips.InterpretationFourier:   "fourier",
+	vips.InterpretationGrey16:    "grey16",
+	vips.InterpretationMatrix:    "matrix",
+	vips.InterpretationScRGB:     "scrgb",
+	vips.InterpretationHSV:       "hsv",
+}
+
+// Limits protect the service from expensive requests.
+type Limits struct {
+	MaxConcurrent      int           // images processed at once, defaults to the number of CPUs
+	QueueSize          int           // requests allowed to wait for a slot
+	QueueTimeout       time.Duration // how long they may wait, 0 = until the request is cancelled
+	MaxInputPixels     int64         // width*height of the decoded source, 0 = unlimited
+	MaxOutputDimension int           // largest requested w or h, 0 = unlimited
+}
+
+type Processor struct {
+	queue  *queue
+	limits Limits
+}
+
+func NewProcessor(limits Limits) *Processor {
+	if limits.MaxConcurrent <= 0 {
+		limits.MaxConcurrent = runtime.NumCPU()
+	}
 
-func NewProcessor() *Processor {
 	vips.Startup(&vips.Config{
 		ConcurrencyLevel: 8,
 		MaxCacheSize:     200,
 		MaxCacheMem:      100 * 1024 * 1024,
 		MaxCacheFiles:    500,
 	})
-	return &Processor{}
+	return &Processor{
+		queue:  newQueue(limits.MaxConcurrent, limits.QueueSize, limits.QueueTimeout),
+		limits: limits,
+	}
+}
+
+func (p *Processor) Stats() Stats {
+	return p.queue.stats()
 }
 
-func (p *Processor) Transform(imageData []byte, opts TransformOptions) ([]byte, error) {
-	// Load image
+func (p *Processor) Transform(ctx context.Context, imageData []byte, opts TransformOptions) (*Result, error) {
+	if err := p.checkOutput(opts.Width, opts.Height); err != nil {
+		return nil, err
+	}
+	if err := p.checkHeader(imageData); err != nil {
+		return nil, err
+	}
+
+	if err := p.queue.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer p.queue.release()
+
+	// Load image. For the formats libvips reads itself only the header is
+	// parsed here, pixels are decoded on first use.
 	img, err := vips.NewImageFromBuffer(imageData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load image: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
 	}
 	defer img.Close()
 
+	// Formats sniffing doesn't know are checked before anything forces a full decode
+	if err := p.checkInput(img.Width(), img.Height()); err != nil {
+		return nil, err
+	}
+
 	// Auto-rotate based on EXIF
 	if err := img.AutoRotate(); err != nil {
 		return nil, fmt.Errorf("failed to auto-rotate: %w", err)
 	}
 
 	// Manual rotation
-	if opts.Rotate > 0 {
-		angle := vips.Angle0
+	if opts.Rotate != 0 {
+		var angle vips.Angle
 		switch opts.Rotate {
 		case 90:
 			angle = vips.Angle90
@@ -61,6 +163,8 @@ func (p *Processor) Transform(imageData []byte, opts TransformOptions) ([]byte,
 			angle = vips.Angle180
 		case 270:
 			angle = vips.Angle270
+		default:
+			return nil, fmt.Errorf("%w: rotate %d, must be 90, 180 or 270", ErrInvalidOption, opts.Rotate)
 		}
 		if err := img.Rotate(angle); err != nil {
 			return nil, fmt.Errorf("failed to rotate: %w", err)
@@ -85,6 +189,8 @@ func (p *Processor) Transform(imageData []byte, opts TransformOptions) ([]byte,
 			if err := img.Flip(vips.DirectionVertical); err != nil {
 				return nil, fmt.Errorf("failed to flip: %w", err)
 			}
+		default:
+			return nil, fmt.Errorf("%w: flip %q, must be h, v or both", ErrInvalidOption, opts.Flip)
 		}
 	}
 
@@ -105,10 +211,14 @@ func (p *Processor) Transform(imageData []byte, opts TransformOptions) ([]byte,
 	// Manual crop
 	if opts.Crop != "" {
 		var x, y, w, h int
-		if _, err := fmt.Sscanf(opts.Crop, "%d,%d,%d,%d", &x, &y, &w, &h); err == nil {
-			if err := img.ExtractArea(x, y, w, h); err != nil {
-				return nil, fmt.Errorf("failed to crop: %w", err)
-			}
+		if _, err := fmt.Sscanf(opts.Crop, "%d,%d,%d,%d", &x, &y, &w, &h); err != nil {
+			return nil, fmt.Errorf("%w: crop %q, must be x,y,width,height", ErrInvalidOption, opts.Crop)
+		}
+		if x < 0 || y < 0 || w <= 0 || h <= 0 || x+w > img.Width() || y+h > img.Height() {
+			return nil, fmt.Errorf("%w: crop %q is outside the %dx%d image", ErrInvalidOption, opts.Crop, img.Width(), img.Height())
+		}
+		if err := img.ExtractArea(x, y, w, h); err != nil {
+			return nil, fmt.Errorf("failed to crop: %w", err)
 		}
 	}
 
@@ -166,7 +276,7 @@ func (p *Processor) Transform(imageData []byte, opts TransformOptions) ([]byte,
 	}
 
 	// Saturation adjustment
-	if opts.Saturation != 0 && opts.Saturation != 1.0 {
+	if opts.Saturation != 1.0 {
 		// Convert to LAB color space for saturation adjustment
 		originalSpace := img.Interpretation()
 
@@ -200,9 +310,22 @@ func (p *Processor) Transform(imageData []byte, opts TransformOptions) ([]byte,
 		stripMetadata = false
 	}
 
+	// Resolve the output format, anything unrecognized is exported as JPEG
+	format := opts.Format
+	switch format {
+	case "webp", "avif", "png":
+	case "auto":
+		format = "jpeg"
+		if img.HasAlpha() {
+			format = "png"
+		}
+	default:
+		format = "jpeg"
+	}
+
 	// Export with format-specific optimizations
 	var output []byte
-	switch opts.Format {
+	switch format {
 	case "webp":
 		params := vips.NewWebpExportParams()
 		params.Quality = quality
@@ -225,8 +348,6 @@ func (p *Processor) Transform(imageData []byte, opts TransformOptions) ([]byte,
 		params.Filter = vips.PngFilterAll
 		output, _, err = img.ExportPng(params)
 
-	case "jpg", "jpeg":
-		fallthrough
 	default:
 		params := vips.NewJpegExportParams()
 		params.Quality = quality
@@ -241,7 +362,92 @@ func (p *Processor) Transform(imageData []byte, opts TransformOptions) ([]byte,
 		return nil, fmt.Errorf("failed to export image: %w", err)
 	}
 
-	return output, nil
+	return &Result{
+		Data:   output,
+		Format: format,
+		Width:  img.Width(),
+		Height: img.Height(),
+	}, nil
+}
+
+// checkHeader rejects decompression bombs before govips sees them, it
+// decodes some formats (BMP) completely while loading. Only the header is
+// read, formats it doesn't know are left to checkInput after loading.
+func (p *Processor) checkHeader(imageData []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(imageData))
+	if err != nil {
+		return nil
+	}
+	return p.checkInput(config.Width, config.Height)
+}
+
+func (p *Processor) checkInput(width, height int) error {
+	if limit := p.limits.MaxInputPixels; limit > 0 && int64(width)*int64(height) > limit {
+		return fmt.Errorf("%w: image is %dx%d, limit is %d pixels", ErrTooManyPixels, width, height, limit)
+	}
+	return nil
+}
+
+// checkOutput caps the requested w and h. Images kept at their own size are
+// bounded by MaxInputPixels instead.
+func (p *Processor) checkOutput(width, height int) error {
+	if limit := p.limits.MaxOutputDimension; limit > 0 && (width > limit || height > limit) {
+		return fmt.Errorf("%w: requested %dx%d, limit is %d", ErrOutputTooLarge, width, height, limit)
+	}
+	return nil
+}
+
+// Info loads the image header and reports its properties without transforming it.
+func (p *Processor) Info(ctx context.Context, imageData []byte) (*ImageInfo, error) {
+	if err := p.checkHeader(imageData); err != nil {
+		return nil, err
+	}
+
+	if err := p.queue.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer p.queue.release()
+
+	img, err := vips.NewImageFromBuffer(imageData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+	defer img.Close()
+
+	format, ok := vips.ImageTypes[img.OriginalFormat()]
+	if !ok {
+		format = "unknown"
+	}
+	if img.OriginalFormat() == vips.ImageTypeAVIF {
+		format = "avif"
+	}
+
+	colorSpace, ok := interpretationNames[img.Interpretation()]
+	if !ok {
+		colorSpace = "unknown"
+	}
+
+	hasXMP := false
+	for _, field := range img.GetFields() {
+		if field == "xmp-data" {
+			hasXMP = true
+			break
+		}
+	}
+
+	return &ImageInfo{
+		Width:       img.Width(),
+		Height:      img.Height(),
+		Format:      format,
+		ColorSpace:  colorSpace,
+		HasAlpha:    img.HasAlpha(),
+		Orientation: img.Orientation(),
+		Pages:       img.Pages(),
+		Size:        len(imageData),
+		HasExif:     img.HasExif(),
+		HasICC:      img.HasICCProfile(),
+		HasXMP:      hasXMP,
+	}, nil
 }
 
 func (p *Processor) Shutdown() {
@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueFull    = errors.New("processing queue is full")
+	ErrQueueTimeout = errors.New("timed out waiting for a processing slot")
+)
+
+// Stats is a snapshot of the processing queue.
+type Stats struct {
+	Active        int64
+	Queued        int64
+	MaxConcurrent int
+	QueueSize     int
+	Processed     int64
+	Rejected      int64
+	TimedOut      int64
+}
+
+// queue bounds the number of images processed at once. Up to size callers
+// may wait for a slot, for at most timeout (zero: until their context is
+// done); anything beyond that is shed.
+type queue struct {
+	slots   chan struct{}
+	size    int
+	timeout time.Duration
+
+	active    atomic.Int64
+	waiting   atomic.Int64
+	processed atomic.Int64
+	rejected  atomic.Int64
+	timedOut  atomic.Int64
+}
+
+func newQueue(maxConcurrent, size int, timeout time.Duration) *queue {
+	return &queue{
+		slots:   make(chan struct{}, maxConcurrent),
+		size:    size,
+		timeout: timeout,
+	}
+}
+
+func (q *queue) acquire(ctx context.Context) error {
+	select {
+	case q.slots <- struct{}{}:
+		q.active.Add(1)
+		return nil
+	default:
+	}
+
+	if q.waiting.Add(1) > int64(q.size) {
+		q.waiting.Add(-1)
+		q.rejected.Add(1)
+		return ErrQueueFull
+	}
+	defer q.waiting.Add(-1)
+
+	var expired <-chan time.Time
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		q.active.Add(1)
+		return nil
+	case <-expired:
+		q.timedOut.Add(1)
+		return ErrQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *queue) release() {
+	q.active.Add(-1)
+	q.processed.Add(1)
+	<-q.slots
+}
+
+func (q *queue) stats() Stats {
+	return Stats{
+		Active:        q.active.Load(),
+		Queued:        q.waiting.Load(),
+		MaxConcurrent: cap(q.slots),
+		QueueSize:     q.size,
+		Processed:     q.processed.Load(),
+		Rejected:      q.rejected.Load(),
+		TimedOut:      q.timedOut.Load(),
+	}
+}
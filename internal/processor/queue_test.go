@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueAcquire(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		timeout time.Duration
+		cancel  bool
+		want    error
+	}{
+		{"full", 0, time.Second, false, ErrQueueFull},
+		{"timeout", 1, 10 * time.Millisecond, false, ErrQueueTimeout},
+		{"cancelled", 1, time.Hour, true, context.Canceled},
+		{"no timeout waits for the context", 1, 0, true, context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue(1, tt.size, tt.timeout)
+			if err := q.acquire(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(20*time.Millisecond, cancel)
+			}
+
+			if err := q.acquire(ctx); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if queued := q.stats().Queued; queued != 0 {
+				t.Errorf("%d still queued", queued)
+			}
+		})
+	}
+}
+
+func TestQueueAcquireAfterRelease(t *testing.T) {
+	q := newQueue(1, 1, 0)
+	if err := q.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(20*time.Millisecond, q.release)
+
+	if err := q.acquire(context.Background()); err != nil {
+		t.Errorf("err = %v, want a slot once released", err)
+	}
+}
@@ -0,0 +1,72 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// File serves file:///path URLs from a single directory. Paths are resolved
+// inside it with os.Root, so neither ".." nor symlinks can escape it.
+type File struct {
+	root *os.Root
+}
+
+func NewFile(dir string) (*File, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &File{root: root}, nil
+}
+
+func (s *File) Fetch(ctx context.Context, r *Request) (*Image, error) {
+	if r.URL.Host != "" && r.URL.Host != "localhost" {
+		return nil, fmt.Errorf("file source: unexpected host %q", r.URL.Host)
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+
+	f, err := s.root.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, ErrNotFound
+	}
+	// Same second granularity as Last-Modified
+	if !r.LastModified.IsZero() && !info.ModTime().Truncate(time.Second).After(r.LastModified) {
+		return &Image{NotModified: true, ETag: r.ETag, LastModified: r.LastModified}, nil
+	}
+	if info.Size() > r.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	data, err := readLimited(f, r.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Image{Data: data, LastModified: info.ModTime()}, nil
+}
+
+func (s *File) Close() error {
+	return s.root.Close()
+}
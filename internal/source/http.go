@@ -0,0 +1,248 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"image-service/internal/allowlist"
+	"image-service/internal/origin"
+	"image-service/internal/ssrf"
+)
+
+type HTTPConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int // 0 means unlimited
+	IdleConnTimeout     time.Duration
+	HTTP2               bool
+	Retries             int           // extra attempts after a transient failure
+	RetryBackoff        time.Duration // doubled after every attempt
+	MaxRedirects        int
+}
+
+// HTTP fetches from http(s) origins, applying their origin profiles. It
+// keeps one client for its whole life so connections are pooled.
+type HTTP struct {
+	cfg     HTTPConfig
+	origins *origin.Registry
+	allowed *allowlist.List
+	client  *http.Client
+}
+
+func NewHTTP(cfg HTTPConfig, origins *origin.Registry, allowed *allowlist.List, guard *ssrf.Guard) *HTTP {
+	s := &HTTP{
+		cfg:     cfg,
+		origins: origins,
+		allowed: allowed,
+	}
+
+	dialer := guard.Dialer(&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+
+	// Timeouts are per request, from the origin profile
+	s.client = &http.Client{
+		CheckRedirect: s.checkRedirect,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   cfg.HTTP2,
+			MaxIdleConns:        cfg.MaxIdleConns,
+			MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     cfg.MaxConnsPerHost,
+			IdleConnTimeout:     cfg.IdleConnTimeout,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+
+	return s
+}
+
+func (s *HTTP) Fetch(ctx context.Context, r *Request) (*Image, error) {
+	// Checked here too, not every caller goes through the Auth middleware
+	if !s.allowed.Allowed(r.URL) {
+		return nil, ErrNotAllowed
+	}
+
+	profile := s.origins.Match(r.URL)
+	timeout := 15 * time.Second
+	if profile.Timeout > 0 {
+		timeout = profile.Timeout
+	}
+	maxSize := r.MaxSize
+	if profile.MaxSize > 0 {
+		maxSize = profile.MaxSize
+	}
+
+	for attempt := 0; ; attempt++ {
+		image, err := s.fetch(ctx, r, profile, timeout, maxSize)
+		if err == nil || attempt >= s.cfg.Retries || !retryable(ctx, err) {
+			return image, err
+		}
+
+		// Exponential backoff with jitter, so retries from many requests spread out
+		delay := s.cfg.RetryBackoff << attempt
+		delay = delay/2 + rand.N(delay/2+1)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *HTTP) fetch(ctx context.Context, r *Request, profile *origin.Profile, timeout time.Duration, maxSize int64) (*Image, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	baseURL := r.URL.Scheme + "://" + r.URL.Host
+
+	req, err := http.NewRequestWithContext(ctx, "GET", r.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Comprehensive browser headers
+	req.Header.Set("X-Image-Service", "railway-transform-v1")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("Accept", "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	req.Header.Set("Referer", baseURL+"/")
+	req.Header.Set("Origin", baseURL)
+	req.Header.Set("Sec-Ch-Ua", `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`)
+	req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
+	req.Header.Set("Sec-Ch-Ua-Platform", `"Windows"`)
+	req.Header.Set("Sec-Fetch-Dest", "image")
+	req.Header.Set("Sec-Fetch-Mode", "no-cors")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	req.Header.Set("DNT", "1")
+	profile.Apply(req, r.Header)
+	setConditionalHeaders(req, r)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && r.conditional() {
+		return notModified(resp, r), nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Drain a little so the connection can go back to the pool
+		io.CopyN(io.Discard, resp.Body, 4096)
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	data, err := readLimited(resp.Body, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Zero when the origin doesn't send one (or sends garbage)
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &Image{Data: data, ETag: resp.Header.Get("ETag"), LastModified: lastModified}, nil
+}
+
+func setConditionalHeaders(req *http.Request, r *Request) {
+	if r.ETag != "" {
+		req.Header.Set("If-None-Match", r.ETag)
+	}
+	if !r.LastModified.IsZero() {
+		req.Header.Set("If-Modified-Since", r.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified describes a 304 response. Validators the origin didn't repeat
+// stay as they were.
+func notModified(resp *http.Response, r *Request) *Image {
+	image := &Image{NotModified: true, ETag: r.ETag, LastModified: r.LastModified}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		image.ETag = etag
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		image.LastModified = lastModified
+	}
+	return image
+}
+
+// StatusError is an unexpected response status from an origin.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "bad status: " + e.Status
+}
+
+// retryable reports whether a failed fetch may succeed when tried again:
+// server errors and network failures, unless the caller is gone.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 && statusErr.Code != http.StatusNotImplemented
+	}
+
+	var blocked *ssrf.BlockedError
+	if errors.As(err, &blocked) {
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// checkRedirect applies the same rules to every redirect hop as to the
+// requested URL. The dialer rejects internal addresses on its own.
+func (s *HTTP) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > s.cfg.MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", s.cfg.MaxRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+	}
+	if !s.allowed.Allowed(req.URL) {
+		return fmt.Errorf("redirect to %s: domain not allowed", req.URL.Host)
+	}
+
+	// Origin headers and credentials only go to URLs under the profile that
+	// supplied them. Go drops Authorization on cross-domain redirects, but
+	// not when the same host redirects outside the profile's base path.
+	if profile := s.origins.Match(via[0].URL); profile != s.origins.Match(req.URL) {
+		for key := range profile.Headers {
+			req.Header.Del(key)
+		}
+		for _, key := range profile.ForwardHeaders {
+			req.Header.Del(key)
+		}
+		if profile.BearerToken != "" || profile.BasicUser != "" {
+			req.Header.Del("Authorization")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"image-service/internal/allowlist"
+	"image-service/internal/origin"
+	"image-service/internal/ssrf"
+	"image-service/pkg/config"
+)
+
+func TestHTTPFetchChecksAllowlist(t *testing.T) {
+	allowed, err := allowlist.New([]string{"allowed.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins, err := origin.NewRegistry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewHTTP(HTTPConfig{}, origins, allowed, ssrf.NewGuard(nil))
+
+	for _, raw := range []string{"https://evil-host/a.jpg", "http://allowed.com%2F@evil-host/"} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.Fetch(context.Background(), &Request{URL: u, MaxSize: 1024})
+		if !errors.Is(err, ErrNotAllowed) {
+			t.Errorf("Fetch(%s) err = %v, want ErrNotAllowed", raw, err)
+		}
+	}
+}
+
+func TestCheckRedirectStripsOriginCredentials(t *testing.T) {
+	allowed, err := allowlist.New([]string{"allowed.com", "other.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins, err := origin.NewRegistry(map[string]config.OriginConfig{
+		"cms": {
+			BaseURL:        "https://allowed.com/private",
+			Headers:        map[string]string{"X-Api-Key": "secret"},
+			BearerToken:    "token",
+			ForwardHeaders: []string{"X-Tenant"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewHTTP(HTTPConfig{MaxRedirects: 5}, origins, allowed, ssrf.NewGuard(nil))
+
+	tests := []struct {
+		target string
+		keep   bool
+	}{
+		{"https://allowed.com/private/b.jpg", true},
+		{"https://allowed.com/public/b.jpg", false},
+		{"https://allowed.com/private-other/b.jpg", false},
+		{"https://allowed.com/private/../public/b.jpg", false},
+		{"http://allowed.com/private/b.jpg", false},
+		{"https://other.com/private/b.jpg", false},
+	}
+
+	for _, tt := range tests {
+		first, _ := http.NewRequest("GET", "https://allowed.com/private/a.jpg", nil)
+		origins.Match(first.URL).Apply(first, http.Header{"X-Tenant": {"t1"}})
+
+		// Redirects start from a copy of the first request's headers
+		req, _ := http.NewRequest("GET", tt.target, nil)
+		req.Header = first.Header.Clone()
+
+		if err := s.checkRedirect(req, []*http.Request{first}); err != nil {
+			t.Fatalf("%s: %v", tt.target, err)
+		}
+		for _, key := range []string{"Authorization", "X-Api-Key", "X-Tenant"} {
+			if kept := req.Header.Get(key) != ""; kept != tt.keep {
+				t.Errorf("%s: %s kept = %v, want %v", tt.target, key, kept, tt.keep)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package source fetches original images from the places they live: HTTP
+// origins, a local directory and S3-compatible buckets, picked by URL scheme.
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrTooLarge   = errors.New("image too large")
+	ErrNotFound   = errors.New("image not found")
+	ErrNotAllowed = errors.New("domain not allowed")
+)
+
+type Request struct {
+	URL     *url.URL
+	Header  http.Header // client headers forwarded to HTTP origins
+	MaxSize int64
+
+	// Validators of a cached copy. When the source confirms it is unchanged
+	// the returned Image has NotModified set and no data.
+	ETag         string
+	LastModified time.Time
+}
+
+func (r *Request) conditional() bool {
+	return r.ETag != "" || !r.LastModified.IsZero()
+}
+
+type Image struct {
+	Data         []byte
+	ETag         string
+	LastModified time.Time // zero when unknown
+	NotModified  bool
+}
+
+type Source interface {
+	Fetch(ctx context.Context, req *Request) (*Image, error)
+}
+
+// Sources dispatches to a Source by URL scheme.
+type Sources map[string]Source
+
+func (s Sources) Fetch(ctx context.Context, req *Request) (*Image, error) {
+	src, ok := s[req.URL.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported source scheme %q", req.URL.Scheme)
+	}
+	return src.Fetch(ctx, req)
+}
+
+// readLimited reads r to the end, failing with ErrTooLarge past maxSize.
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,87 @@
+// Package ssrf keeps origin fetches away from internal networks.
+package ssrf
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// Ranges that are never fetched unless explicitly allowed, on top of the
+// loopback, private, link-local, multicast and unspecified checks netip offers.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),         // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),     // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),      // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),     // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),       // reserved, includes broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),      // NAT64, can map onto IPv4 internals
+	netip.MustParsePrefix("64:ff9b:1::/48"),    // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),     // documentation
+	netip.MustParsePrefix("fd00:ec2::254/128"), // AWS metadata over IPv6
+}
+
+type Guard struct {
+	allowed []netip.Prefix
+}
+
+// NewGuard blocks internal addresses except those inside allowed, e.g. a
+// private subnet hosting a trusted origin.
+func NewGuard(allowed []netip.Prefix) *Guard {
+	return &Guard{allowed: allowed}
+}
+
+// Blocked reports whether connecting to addr is forbidden.
+func (g *Guard) Blocked(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, prefix := range g.allowed {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() || addr.IsUnspecified() {
+		return true
+	}
+
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Control is a net.Dialer Control hook. It runs after DNS resolution with the
+// address actually being dialed, so hostnames that resolve (or rebind) to
+// internal addresses are caught too.
+func (g *Guard) Control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("ssrf: unexpected dial address %q: %w", address, err)
+	}
+
+	if g.Blocked(addrPort.Addr()) {
+		return &BlockedError{Addr: addrPort.Addr()}
+	}
+	return nil
+}
+
+// Dialer returns a dialer that refuses to connect to blocked addresses.
+func (g *Guard) Dialer(base *net.Dialer) *net.Dialer {
+	d := *base
+	d.Control = g.Control
+	return &d
+}
+
+type BlockedError struct {
+	Addr netip.Addr
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("ssrf: connections to %s are not allowed", e.Addr)
+}
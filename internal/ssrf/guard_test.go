@@ -0,0 +1,75 @@
+package ssrf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestBlocked(t *testing.T) {
+	tests := []struct {
+		addr    string
+		allowed []string
+		want    bool
+	}{
+		{"93.184.216.34", nil, false},
+		{"2606:2800:220:1:248:1893:25c8:1946", nil, false},
+		{"127.0.0.1", nil, true},
+		{"::1", nil, true},
+		{"10.1.2.3", nil, true},
+		{"172.16.0.1", nil, true},
+		{"192.168.1.1", nil, true},
+		{"169.254.169.254", nil, true},
+		{"fe80::1", nil, true},
+		{"fc00::1", nil, true},
+		{"0.0.0.0", nil, true},
+		{"::", nil, true},
+		{"100.64.0.1", nil, true},
+		{"198.18.0.1", nil, true},
+		{"255.255.255.255", nil, true},
+		{"224.0.0.1", nil, true},
+		{"::ffff:127.0.0.1", nil, true},
+		{"::ffff:10.0.0.1", nil, true},
+		{"64:ff9b::a00:1", nil, true},
+		{"fd00:ec2::254", nil, true},
+		{"10.1.2.3", []string{"10.1.0.0/16"}, false},
+		{"10.2.0.1", []string{"10.1.0.0/16"}, true},
+		{"::ffff:10.1.2.3", []string{"10.1.0.0/16"}, false},
+	}
+
+	for _, tt := range tests {
+		var allowed []netip.Prefix
+		for _, cidr := range tt.allowed {
+			allowed = append(allowed, netip.MustParsePrefix(cidr))
+		}
+
+		if got := NewGuard(allowed).Blocked(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Blocked(%s) with allowed %v = %t, want %t", tt.addr, tt.allowed, got, tt.want)
+		}
+	}
+}
+
+func TestDialerRefusesBlockedAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dialer := NewGuard(nil).Dialer(&net.Dialer{})
+	_, err := dialer.DialContext(context.Background(), "tcp", server.Listener.Addr().String())
+
+	var blocked *BlockedError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("dial to %s: err = %v, want a BlockedError", server.Listener.Addr(), err)
+	}
+
+	// The same server is reachable once its range is allowed
+	dialer = NewGuard([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}).Dialer(&net.Dialer{})
+	conn, err := dialer.DialContext(context.Background(), "tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial with loopback allowed: %v", err)
+	}
+	conn.Close()
+}
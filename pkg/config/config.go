@@ -1,6 +1,8 @@
 package config
 
 import (
+    "fmt"
+    "net/netip"
     "os"
     "strconv"
     "strings"
@@ -11,21 +13,134 @@ type Config struct {
     RedisURL       string
     RedisPassword  string
     AllowedDomains []string
+    DeniedDomains  []string
+    AllowedCIDRs   []netip.Prefix
+    MaxRedirects   int
+    OriginIdle     int
+    OriginIdleHost int
+    OriginMaxConns int
+    OriginIdleTime int
+    OriginHTTP2    bool
+    OriginRetries  int
+    OriginBackoff  int
+    FileRoot       string
+    S3Endpoint     string
+    S3Region       string
+    S3AccessKey    string
+    S3SecretKey    string
+    S3SessionToken string
+    CacheBackend   string
     CacheTTL       int
+    CacheSoftTTL   int
+    NegativeTTL    int
+    CacheMemSize   int64
+    CacheDir       string
+    CacheDiskSize  int64
+    CacheLock      bool
+    OriginalsCache string
+    OriginalsSize  int64 // memory and disk only, Redis uses its maxmemory
+    OriginalsDir   string
+    OriginalsTTL   int
+    OriginalsAge   int
+    LockTimeout    int
     MaxImageSize   int64
+    MaxInputPixels int64
+    MaxOutputDim   int
+    MaxTransforms  int
+    QueueSize      int
+    QueueTimeout   int
     RateLimit      int
+    SigningKeys    []string
+    AdminToken     string
+    CacheUploads   bool
+    ConfigFile     string
+    Presets        map[string]map[string]string
+    Fallbacks      map[string]string // preset name to fallback image path
+    PresetsOnly    bool
+    StrictParams   bool // reject unknown query parameters
+    FallbackImage  string
+    FallbackMaxAge int
+    Origins        map[string]OriginConfig
 }
 
-func Load() *Config {
-    return &Config{
+func Load() (*Config, error) {
+    cfg := &Config{
         Port:           getEnv("PORT", "3000"),
         RedisURL:       getEnv("REDIS_URL", "localhost:6379"),
         RedisPassword:  getEnv("REDIS_PASSWORD", ""),
         AllowedDomains: strings.Split(getEnv("ALLOWED_DOMAINS", ""), ","),
+        DeniedDomains:  getEnvList("DENIED_DOMAINS"),
+        MaxRedirects:   getEnvInt("MAX_REDIRECTS", 5),
+        OriginIdle:     getEnvInt("ORIGIN_MAX_IDLE_CONNS", 100),
+        OriginIdleHost: getEnvInt("ORIGIN_MAX_IDLE_CONNS_PER_HOST", 32),
+        OriginMaxConns: getEnvInt("ORIGIN_MAX_CONNS_PER_HOST", 0),
+        OriginIdleTime: getEnvInt("ORIGIN_IDLE_CONN_TIMEOUT", 90),
+        OriginHTTP2:    getEnvBool("ORIGIN_HTTP2", true),
+        OriginRetries:  getEnvInt("ORIGIN_RETRIES", 2),
+        OriginBackoff:  getEnvInt("ORIGIN_RETRY_BACKOFF_MS", 200),
+        FileRoot:       getEnv("FILE_SOURCE_ROOT", ""),
+        S3Endpoint:     getEnv("S3_ENDPOINT", ""),
+        S3Region:       getEnv("S3_REGION", getEnv("AWS_REGION", "us-east-1")),
+        S3AccessKey:    getEnv("S3_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+        S3SecretKey:    getEnv("S3_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+        S3SessionToken: getEnv("S3_SESSION_TOKEN", os.Getenv("AWS_SESSION_TOKEN")),
+        CacheBackend:   getEnv("CACHE_BACKEND", "redis"),
         CacheTTL:       getEnvInt("CACHE_TTL", 86400),
+        CacheSoftTTL:   getEnvInt("CACHE_SOFT_TTL", 0),
+        NegativeTTL:    getEnvInt("NEGATIVE_CACHE_TTL", 30),
+        CacheMemSize:   int64(getEnvInt("CACHE_MEMORY_SIZE", 256*1024*1024)),
+        CacheDir:       getEnv("CACHE_DIR", "./cache"),
+        CacheDiskSize:  int64(getEnvInt("CACHE_DISK_SIZE", 10*1024*1024*1024)),
+        CacheLock:      getEnvBool("CACHE_LOCK", false),
+        OriginalsCache: getEnv("ORIGINALS_CACHE", ""),
+        OriginalsSize:  int64(getEnvInt("ORIGINALS_CACHE_SIZE", 512*1024*1024)),
+        OriginalsDir:   getEnv("ORIGINALS_CACHE_DIR", "./cache-originals"),
+        OriginalsTTL:   getEnvInt("ORIGINALS_CACHE_TTL", 7*86400),
+        OriginalsAge:   getEnvInt("ORIGINALS_MAX_AGE", 3600),
+        LockTimeout:    getEnvInt("CACHE_LOCK_TIMEOUT", 30),
         MaxImageSize:   int64(getEnvInt("MAX_IMAGE_SIZE", 10*1024*1024)),
+        MaxInputPixels: int64(getEnvInt("MAX_INPUT_PIXELS", 40000000)),
+        MaxOutputDim:   getEnvInt("MAX_OUTPUT_DIMENSION", 8192),
+        MaxTransforms:  getEnvInt("MAX_CONCURRENT_TRANSFORMS", 0),
+        QueueSize:      getEnvInt("TRANSFORM_QUEUE_SIZE", 100),
+        QueueTimeout:   getEnvInt("TRANSFORM_QUEUE_TIMEOUT", 10),
         RateLimit:      getEnvInt("RATE_LIMIT", 100),
+        SigningKeys:    getEnvList("SIGNING_KEYS"),
+        AdminToken:     getEnv("ADMIN_TOKEN", ""),
+        CacheUploads:   getEnvBool("CACHE_UPLOADS", false),
+        ConfigFile:     getEnv("CONFIG_FILE", ""),
+        PresetsOnly:    getEnvBool("PRESETS_ONLY", false),
+        StrictParams:   getEnvBool("STRICT_PARAMS", false),
+        FallbackImage:  getEnv("FALLBACK_IMAGE", ""),
+        FallbackMaxAge: getEnvInt("FALLBACK_MAX_AGE", 60),
     }
+
+    // Internal ranges origins may resolve to, everything else private is blocked
+    for _, cidr := range getEnvList("ORIGIN_ALLOW_CIDRS") {
+        prefix, err := netip.ParsePrefix(cidr)
+        if err != nil {
+            return nil, fmt.Errorf("invalid ORIGIN_ALLOW_CIDRS entry: %w", err)
+        }
+        cfg.AllowedCIDRs = append(cfg.AllowedCIDRs, prefix)
+    }
+
+    // 0 waits for a processing slot as long as the request lasts
+    if cfg.QueueTimeout < 0 {
+        return nil, fmt.Errorf("TRANSFORM_QUEUE_TIMEOUT (%d) must not be negative", cfg.QueueTimeout)
+    }
+
+    // Past the soft TTL entries are served stale until the hard one, which has to come later
+    if cfg.CacheSoftTTL > 0 && cfg.CacheTTL > 0 && cfg.CacheSoftTTL >= cfg.CacheTTL {
+        return nil, fmt.Errorf("CACHE_SOFT_TTL (%d) must be less than CACHE_TTL (%d)", cfg.CacheSoftTTL, cfg.CacheTTL)
+    }
+
+    if cfg.ConfigFile != "" {
+        if err := loadFile(cfg.ConfigFile, cfg); err != nil {
+            return nil, err
+        }
+    }
+
+    return cfg, nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -42,4 +157,23 @@ func getEnvInt(key string, defaultValue int) int {
         }
     }
     return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+    if value := os.Getenv(key); value != "" {
+        if boolVal, err := strconv.ParseBool(value); err == nil {
+            return boolVal
+        }
+    }
+    return defaultValue
+}
+
+func getEnvList(key string) []string {
+    var list []string
+    for _, item := range strings.Split(os.Getenv(key), ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            list = append(list, item)
+        }
+    }
+    return list
 }
\ No newline at end of file
@@ -0,0 +1,92 @@
+package config
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+)
+
+// fileConfig is the layout of CONFIG_FILE, for settings that don't fit in
+// environment variables:
+//
+//    {
+//      "presets": {
+//        "avatar": {"w": 400, "h": 400, "fit": "cover", "f": "webp", "q": 75, "fallback": "/assets/avatar.png"}
+//      },
+//      "origins": {
+//        "cms": {
+//          "base_url": "https://cms.example.com/media",
+//          "headers": {"X-Api-Key": "${CMS_API_KEY}"},
+//          "timeout": 5,
+//          "max_size": 20971520,
+//          "forward_headers": ["Cookie"]
+//        }
+//      }
+//    }
+//
+// Credentials and header values may reference environment variables as
+// ${NAME} so secrets can stay out of the file.
+type fileConfig struct {
+    Presets map[string]map[string]interface{} `json:"presets"`
+    Origins map[string]OriginConfig           `json:"origins"`
+}
+
+type OriginConfig struct {
+    BaseURL        string            `json:"base_url"`
+    Headers        map[string]string `json:"headers"`
+    BearerToken    string            `json:"bearer_token"`
+    BasicAuth      *BasicAuth        `json:"basic_auth"`
+    Timeout        int               `json:"timeout"`  // seconds
+    MaxSize        int64             `json:"max_size"` // bytes
+    ForwardHeaders []string          `json:"forward_headers"`
+}
+
+type BasicAuth struct {
+    Username string `json:"username"`
+    Password string `json:"password"`
+}
+
+func loadFile(path string, cfg *Config) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return fmt.Errorf("failed to read config file: %w", err)
+    }
+
+    var file fileConfig
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    if err := decoder.Decode(&file); err != nil {
+        return fmt.Errorf("failed to parse config file %s: %w", path, err)
+    }
+
+    cfg.Presets = make(map[string]map[string]string, len(file.Presets))
+    cfg.Fallbacks = make(map[string]string)
+    for name, params := range file.Presets {
+        // Preset values use the same names and formats as query parameters
+        preset := make(map[string]string, len(params))
+        for key, value := range params {
+            if key == "fallback" {
+                cfg.Fallbacks[name] = fmt.Sprint(value)
+                continue
+            }
+            preset[key] = fmt.Sprint(value)
+        }
+        cfg.Presets[name] = preset
+    }
+
+    cfg.Origins = make(map[string]OriginConfig, len(file.Origins))
+    for name, origin := range file.Origins {
+        for key, value := range origin.Headers {
+            origin.Headers[key] = os.ExpandEnv(value)
+        }
+        origin.BearerToken = os.ExpandEnv(origin.BearerToken)
+        if origin.BasicAuth != nil {
+            origin.BasicAuth.Username = os.ExpandEnv(origin.BasicAuth.Username)
+            origin.BasicAuth.Password = os.ExpandEnv(origin.BasicAuth.Password)
+        }
+        cfg.Origins[name] = origin
+    }
+
+    return nil
+}